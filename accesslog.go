@@ -0,0 +1,140 @@
+package centrifuge
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// access log fields
+const (
+	fieldRequestID      string = "request_id"
+	fieldMethod         string = "method"
+	fieldClient         string = "client"
+	fieldUser           string = "user"
+	fieldTransport      string = "transport"
+	fieldChannel        string = "channel"
+	fieldRPCMethod      string = "rpc_method"
+	fieldDuration       string = "duration"
+	fieldResult         string = "result"
+	fieldErrorCode      string = "error_code"
+	fieldDisconnectCode string = "disconnect_code"
+	// optional, redacted fields, not included by default
+	fieldMetadata string = "metadata"
+	fieldData     string = "data"
+	// optional, not included by default: the PID is only known if the worker reports it in the
+	// response context, which the worker SDK does not do, the field is omitted otherwise
+	fieldWorkerPID string = "worker_pid"
+)
+
+// accessLogFields is the default set of access log fields
+func accessLogFields() []string {
+	return []string{
+		fieldRequestID,
+		fieldMethod,
+		fieldClient,
+		fieldUser,
+		fieldTransport,
+		fieldChannel,
+		fieldRPCMethod,
+		fieldDuration,
+		fieldResult,
+		fieldErrorCode,
+		fieldDisconnectCode,
+	}
+}
+
+const accessLogMiddleware string = "access_log"
+
+// accessLog writes one record per proxy call. Unless placed by the `middleware` list, it is
+// installed as the outermost middleware, so the duration covers the whole chain.
+type accessLog struct {
+	log    *slog.Logger
+	fields []string
+	red    *redactor
+}
+
+func newAccessLog(log *slog.Logger, fields []string, red *redactor) *accessLog {
+	return &accessLog{
+		log:    log,
+		fields: fields,
+		red:    red,
+	}
+}
+
+func (a *accessLog) Name() string {
+	return accessLogMiddleware
+}
+
+func (a *accessLog) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		start := time.Now()
+		resp, err := next(ctx, req)
+		a.write(ctx, req, resp, err, time.Since(start))
+
+		return resp, err
+	}
+}
+
+func (a *accessLog) write(ctx context.Context, req *Request, resp proto.Message, err error, took time.Duration) {
+	attrs := make([]slog.Attr, 0, len(a.fields)+1)
+
+	for _, f := range a.fields {
+		switch f {
+		case fieldRequestID:
+			attrs = append(attrs, slog.String(f, req.ID))
+		case fieldMethod:
+			attrs = append(attrs, slog.String(f, req.Type))
+		case fieldClient:
+			if m, ok := req.Message.(interface{ GetClient() string }); ok {
+				attrs = append(attrs, slog.String(f, m.GetClient()))
+			}
+		case fieldUser:
+			if m, ok := req.Message.(interface{ GetUser() string }); ok {
+				attrs = append(attrs, slog.String(f, m.GetUser()))
+			}
+		case fieldTransport:
+			if m, ok := req.Message.(interface{ GetTransport() string }); ok {
+				attrs = append(attrs, slog.String(f, m.GetTransport()))
+			}
+		case fieldChannel:
+			if m, ok := req.Message.(interface{ GetChannel() string }); ok {
+				attrs = append(attrs, slog.String(f, m.GetChannel()))
+			}
+		case fieldRPCMethod:
+			if m, ok := req.Message.(interface{ GetMethod() string }); ok {
+				attrs = append(attrs, slog.String(f, m.GetMethod()))
+			}
+		case fieldDuration:
+			attrs = append(attrs, slog.Duration(f, took))
+		case fieldResult:
+			attrs = append(attrs, slog.String(f, outcome(resp, err)))
+		case fieldErrorCode:
+			if r, ok := resp.(errorResponse); ok && r.GetError() != nil {
+				attrs = append(attrs, slog.Uint64(f, uint64(r.GetError().GetCode())))
+			}
+		case fieldDisconnectCode:
+			if r, ok := resp.(disconnectResponse); ok && r.GetDisconnect() != nil {
+				attrs = append(attrs, slog.Uint64(f, uint64(r.GetDisconnect().GetCode())))
+			}
+		case fieldWorkerPID:
+			if req.WorkerPID != 0 {
+				attrs = append(attrs, slog.Int64(f, req.WorkerPID))
+			}
+		case fieldMetadata:
+			attrs = append(attrs, slog.Any(f, a.red.metadata(req.Meta)))
+		case fieldData:
+			if m, ok := req.Message.(interface{ GetData() []byte }); ok && len(m.GetData()) > 0 {
+				attrs = append(attrs, slog.String(f, string(a.red.data(m.GetData()))))
+			}
+		}
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	a.log.LogAttrs(ctx, slog.LevelInfo, "proxy request", attrs...)
+}
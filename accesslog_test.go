@@ -0,0 +1,107 @@
+package centrifuge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// logRecord runs a single call through the access log and returns the decoded JSON record.
+func logRecord(t *testing.T, fields []string, req *Request, next Handler) map[string]any {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	al := newAccessLog(slog.New(slog.NewJSONHandler(buf, nil)), fields, newRedactor(&Redact{Paths: []string{"token"}}))
+
+	_, _ = al.Middleware(next)(t.Context(), req)
+
+	rec := map[string]any{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+
+	return rec
+}
+
+func TestAccessLogAllFields(t *testing.T) {
+	req := &Request{
+		ID:   "req-1",
+		Type: subscribeType,
+		Message: &centrifugov1.SubscribeRequest{
+			Client:    "c1",
+			User:      "u1",
+			Transport: "websocket",
+			Channel:   "chat",
+		},
+		WorkerPID: 1234,
+	}
+
+	rec := logRecord(t, append(accessLogFields(), fieldWorkerPID), req, func(_ context.Context, _ *Request) (proto.Message, error) {
+		return &centrifugov1.SubscribeResponse{Error: &centrifugov1.Error{Code: 103}}, nil
+	})
+
+	assert.Equal(t, "proxy request", rec["msg"])
+	assert.Equal(t, "req-1", rec[fieldRequestID])
+	assert.Equal(t, subscribeType, rec[fieldMethod])
+	assert.Equal(t, "c1", rec[fieldClient])
+	assert.Equal(t, "u1", rec[fieldUser])
+	assert.Equal(t, "websocket", rec[fieldTransport])
+	assert.Equal(t, "chat", rec[fieldChannel])
+	assert.Equal(t, resultError, rec[fieldResult])
+	assert.InDelta(t, 103, rec[fieldErrorCode], 0)
+	assert.Contains(t, rec, fieldDuration)
+	assert.InDelta(t, 1234, rec[fieldWorkerPID], 0)
+	// subscribe requests carry no RPC method and the worker did not disconnect
+	assert.NotContains(t, rec, fieldRPCMethod)
+	assert.NotContains(t, rec, fieldDisconnectCode)
+}
+
+func TestAccessLogSelectedFields(t *testing.T) {
+	req := &Request{ID: "req-2", Type: rpcType, Message: &centrifugov1.RPCRequest{Method: "getUser", User: "u1"}}
+
+	rec := logRecord(t, []string{fieldRPCMethod, fieldResult}, req, func(_ context.Context, _ *Request) (proto.Message, error) {
+		return nil, errors.New("worker empty response")
+	})
+
+	assert.Equal(t, "getUser", rec[fieldRPCMethod])
+	assert.Equal(t, resultFailed, rec[fieldResult])
+	assert.Equal(t, "worker empty response", rec["error"])
+	assert.NotContains(t, rec, fieldUser)
+	assert.NotContains(t, rec, fieldRequestID)
+}
+
+func TestAccessLogDisconnect(t *testing.T) {
+	req := &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{}}
+
+	rec := logRecord(t, []string{fieldResult, fieldDisconnectCode}, req, func(_ context.Context, _ *Request) (proto.Message, error) {
+		return &centrifugov1.ConnectResponse{Disconnect: &centrifugov1.Disconnect{Code: 4501}}, nil
+	})
+
+	assert.Equal(t, resultDisconnect, rec[fieldResult])
+	assert.InDelta(t, 4501, rec[fieldDisconnectCode], 0)
+}
+
+func TestProxyRequestID(t *testing.T) {
+	p := newTestProxy()
+
+	var ids []string
+	p.handler = func(_ context.Context, req *Request) (proto.Message, error) {
+		ids = append(ids, req.ID)
+		return req.NewResponse(), nil
+	}
+
+	_, err := p.Connect(t.Context(), &centrifugov1.ConnectRequest{})
+	require.NoError(t, err)
+	_, err = p.Connect(t.Context(), &centrifugov1.ConnectRequest{})
+	require.NoError(t, err)
+
+	require.Len(t, ids, 2)
+	assert.NotEmpty(t, ids[0])
+	assert.NotEqual(t, ids[0], ids[1])
+}
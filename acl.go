@@ -0,0 +1,76 @@
+package centrifuge
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const aclMiddleware string = "acl"
+
+// the Centrifugo permission denied error code
+const permissionDeniedCode uint32 = 103
+
+// acl answers the subscribe, subrefresh and publish calls for the channels outside the allowed
+// patterns with the Centrifugo permission denied error, without touching a worker. The proxy types
+// without patterns are not restricted. `{user}` in a pattern is replaced with the user of the call,
+// e.g. `personal:{user}`.
+type acl struct {
+	channels map[string][]string
+}
+
+func newACL(cfg *ACL) *acl {
+	a := &acl{
+		channels: make(map[string][]string),
+	}
+
+	if len(cfg.Subscribe) > 0 {
+		a.channels[subscribeType] = cfg.Subscribe
+		a.channels[subRefreshType] = cfg.Subscribe
+	}
+
+	if len(cfg.Publish) > 0 {
+		a.channels[publishType] = cfg.Publish
+	}
+
+	return a
+}
+
+func (a *acl) Name() string {
+	return aclMiddleware
+}
+
+func (a *acl) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		patterns, ok := a.channels[req.Type]
+		if !ok || a.allowed(patterns, req) {
+			return next(ctx, req)
+		}
+
+		return fallback(req, &Fallback{Error: &FallbackError{Code: permissionDeniedCode, Message: "permission denied"}})
+	}
+}
+
+func (a *acl) allowed(patterns []string, req *Request) bool {
+	m, ok := req.Message.(interface {
+		GetChannel() string
+		GetUser() string
+	})
+	if !ok {
+		return false
+	}
+
+	// the user is matched literally, not as a part of the pattern
+	user := globEscaper.Replace(m.GetUser())
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ReplaceAll(p, "{user}", user), m.GetChannel()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
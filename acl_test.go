@@ -0,0 +1,67 @@
+package centrifuge
+
+import (
+	"context"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestACL(t *testing.T) {
+	a := newACL(&ACL{Subscribe: []string{"chat:*", "personal:{user}"}, Publish: []string{"chat:*"}})
+
+	h := a.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		return req.NewResponse(), nil
+	})
+
+	tests := map[string]struct {
+		req     *Request
+		allowed bool
+	}{
+		"subscribe": {
+			req:     &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{Channel: "chat:1", User: "u1"}, response: &centrifugov1.SubscribeResponse{}},
+			allowed: true,
+		},
+		"own personal channel": {
+			req:     &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{Channel: "personal:u1", User: "u1"}, response: &centrifugov1.SubscribeResponse{}},
+			allowed: true,
+		},
+		"other personal channel": {
+			req: &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{Channel: "personal:u2", User: "u1"}, response: &centrifugov1.SubscribeResponse{}},
+		},
+		"user is not a pattern": {
+			req: &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{Channel: "personal:u2", User: "*"}, response: &centrifugov1.SubscribeResponse{}},
+		},
+		"subrefresh": {
+			req: &Request{Type: subRefreshType, Message: &centrifugov1.SubRefreshRequest{Channel: "news", User: "u1"}, response: &centrifugov1.SubRefreshResponse{}},
+		},
+		"publish": {
+			req: &Request{Type: publishType, Message: &centrifugov1.PublishRequest{Channel: "personal:u1", User: "u1"}, response: &centrifugov1.PublishResponse{}},
+		},
+		"not restricted": {
+			req:     &Request{Type: rpcType, Message: &centrifugov1.RPCRequest{Method: "m"}, response: &centrifugov1.RPCResponse{}},
+			allowed: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := h(t.Context(), tt.req)
+			require.NoError(t, err)
+
+			e := resp.(errorResponse).GetError()
+			if tt.allowed {
+				assert.Nil(t, e)
+
+				return
+			}
+
+			require.NotNil(t, e)
+			assert.Equal(t, permissionDeniedCode, e.GetCode())
+			assert.Equal(t, "permission denied", e.GetMessage())
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package centrifuge
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const authMiddleware string = "auth"
+
+// auth rejects the proxy calls which do not carry one of the configured tokens in the gRPC
+// metadata, e.g. the credentials Centrifugo sends with `grpc_credentials_key` and
+// `grpc_credentials_value`. The rejected calls get the gRPC Unauthenticated code.
+type auth struct {
+	key    string
+	tokens [][]byte
+}
+
+func newAuth(cfg *Auth) *auth {
+	a := &auth{
+		key:    strings.ToLower(cfg.Metadata),
+		tokens: make([][]byte, 0, len(cfg.Tokens)),
+	}
+
+	for _, t := range cfg.Tokens {
+		a.tokens = append(a.tokens, []byte(t))
+	}
+
+	return a
+}
+
+func (a *auth) Name() string {
+	return authMiddleware
+}
+
+func (a *auth) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		if !a.authenticated(req) {
+			return nil, status.Errorf(codes.Unauthenticated, "%s proxy call is not authenticated", req.Type)
+		}
+
+		return next(ctx, req)
+	}
+}
+
+func (a *auth) authenticated(req *Request) bool {
+	for _, v := range req.Meta.Get(a.key) {
+		v = strings.TrimPrefix(v, "Bearer ")
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(v), t) == 1 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package centrifuge
+
+import (
+	"context"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAuth(t *testing.T) {
+	a := newAuth(&Auth{Metadata: "Authorization", Tokens: []string{"t1", "t2"}})
+
+	var calls int
+	h := a.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		calls++
+		return req.NewResponse(), nil
+	})
+
+	tests := map[string]struct {
+		meta metadata.MD
+		ok   bool
+	}{
+		"token":        {meta: metadata.Pairs("authorization", "t2"), ok: true},
+		"bearer token": {meta: metadata.Pairs("authorization", "Bearer t1"), ok: true},
+		"wrong token":  {meta: metadata.Pairs("authorization", "t3")},
+		"other key":    {meta: metadata.Pairs("x-token", "t1")},
+		"no metadata":  {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			calls = 0
+			req := &Request{Type: connectType, Meta: tt.meta, Message: &centrifugov1.ConnectRequest{}, response: &centrifugov1.ConnectResponse{}}
+
+			_, err := h(t.Context(), req)
+			if tt.ok {
+				require.NoError(t, err)
+				assert.Equal(t, 1, calls)
+
+				return
+			}
+
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			assert.Zero(t, calls)
+		})
+	}
+}
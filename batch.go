@@ -0,0 +1,167 @@
+package centrifuge
+
+import (
+	"context"
+	"time"
+
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"github.com/roadrunner-server/errors"
+)
+
+// batchFunc sends a batch of commands to the Centrifugo server API
+type batchFunc func(ctx context.Context, req *v1Client.BatchRequest) (*v1Client.BatchResponse, error)
+
+type batchCall struct {
+	cmd  *v1Client.Command
+	done chan batchResult
+}
+
+type batchResult struct {
+	reply *v1Client.Reply
+	err   error
+}
+
+// batcher collects the publish and broadcast commands arriving within the window, or up to the size
+// limit, and sends them as a single BatchRequest. The batches are sent one after another and executed
+// sequentially by Centrifugo, so the commands are applied in the order they arrived.
+type batcher struct {
+	window  time.Duration
+	maxSize int
+	timeout time.Duration
+	batch   batchFunc
+
+	calls chan *batchCall
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newBatcher(cfg *Batching, batch batchFunc) *batcher {
+	b := &batcher{
+		window:  cfg.Window,
+		maxSize: cfg.MaxSize,
+		timeout: cfg.Timeout,
+		batch:   batch,
+		calls:   make(chan *batchCall, cfg.MaxSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	return b
+}
+
+// do queues the command and waits for its own reply.
+func (b *batcher) do(ctx context.Context, cmd *v1Client.Command) (*v1Client.Reply, error) {
+	call := &batchCall{cmd: cmd, done: make(chan batchResult, 1)}
+
+	select {
+	case b.calls <- call:
+	case <-b.stop:
+		return nil, errors.Str("centrifugo client is stopped")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case res := <-call.done:
+		return res.reply, res.err
+	case <-b.done:
+		// stopped, the pending commands were sent before done was closed
+		select {
+		case res := <-call.done:
+			return res.reply, res.err
+		default:
+			return nil, errors.Str("centrifugo client is stopped")
+		}
+	case <-ctx.Done():
+		// the command is sent anyway, only the caller gives up on the reply
+		return nil, ctx.Err()
+	}
+}
+
+// run collects and sends the batches until the batcher is closed.
+func (b *batcher) run() {
+	defer close(b.done)
+
+	pending := make([]*batchCall, 0, b.maxSize)
+	timer := time.NewTimer(b.window)
+	timer.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			// the commands queued before the stop
+			for len(b.calls) > 0 {
+				pending = append(pending, <-b.calls)
+			}
+
+			b.send(pending)
+			return
+		case call := <-b.calls:
+			pending = append(pending, call)
+			if len(pending) == 1 {
+				timer.Reset(b.window)
+			}
+
+			if len(pending) < b.maxSize {
+				continue
+			}
+
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		b.send(pending)
+		pending = pending[:0]
+	}
+}
+
+func (b *batcher) send(calls []*batchCall) {
+	if len(calls) == 0 {
+		return
+	}
+
+	req := &v1Client.BatchRequest{Commands: make([]*v1Client.Command, len(calls))}
+	for i, call := range calls {
+		call.cmd.Id = uint32(i + 1) //nolint:gosec
+		req.Commands[i] = call.cmd
+	}
+
+	// a hanging call would hold every batch after it
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+
+	resp, err := b.batch(ctx, req)
+	if err != nil {
+		for _, call := range calls {
+			call.done <- batchResult{err: err}
+		}
+
+		return
+	}
+
+	replies := make(map[uint32]*v1Client.Reply, len(resp.GetReplies()))
+	for _, r := range resp.GetReplies() {
+		replies[r.GetId()] = r
+	}
+
+	for i, call := range calls {
+		r, ok := replies[call.cmd.GetId()]
+		if !ok && i < len(resp.GetReplies()) {
+			// the replies are in the order of the commands
+			r, ok = resp.GetReplies()[i], true
+		}
+
+		if !ok {
+			call.done <- batchResult{err: errors.Errorf("no reply to the batched command %d", call.cmd.GetId())}
+			continue
+		}
+
+		call.done <- batchResult{reply: r}
+	}
+}
+
+// close sends the pending commands and stops the batcher.
+func (b *batcher) close() {
+	close(b.stop)
+	<-b.done
+}
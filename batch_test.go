@@ -0,0 +1,168 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBatchAPI answers every publish with the offset equal to its data and records the batches
+type fakeBatchAPI struct {
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (f *fakeBatchAPI) batch(_ context.Context, req *v1Client.BatchRequest) (*v1Client.BatchResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	resp := &v1Client.BatchResponse{}
+	data := make([]string, 0, len(req.GetCommands()))
+
+	for _, cmd := range req.GetCommands() {
+		r := &v1Client.Reply{Id: cmd.GetId()}
+
+		switch {
+		case cmd.GetPublish() != nil:
+			data = append(data, string(cmd.GetPublish().GetData()))
+
+			offset, err := strconv.ParseUint(string(cmd.GetPublish().GetData()), 10, 64)
+			if err != nil {
+				r.Error = &v1Client.Error{Code: 107, Message: "bad request"}
+				break
+			}
+
+			r.Publish = &v1Client.PublishResult{Offset: offset}
+		case cmd.GetBroadcast() != nil:
+			data = append(data, string(cmd.GetBroadcast().GetData()))
+			r.Broadcast = &v1Client.BroadcastResult{}
+		}
+
+		resp.Replies = append(resp.Replies, r)
+	}
+
+	f.batches = append(f.batches, data)
+
+	return resp, nil
+}
+
+func startBatcher(cfg *Batching, batch batchFunc) *batcher {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+
+	b := newBatcher(cfg, batch)
+	go b.run()
+
+	return b
+}
+
+func (f *fakeBatchAPI) sent() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.batches
+}
+
+func TestBatcherReplies(t *testing.T) {
+	api := &fakeBatchAPI{}
+	c := &client{batcher: startBatcher(&Batching{Window: time.Millisecond * 50, MaxSize: 100}, api.batch)}
+	t.Cleanup(c.batcher.close)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Go(func() {
+			resp, err := c.publish(t.Context(), &v1Client.PublishRequest{Channel: "news", Data: []byte(strconv.Itoa(i))})
+			assert.NoError(t, err)
+			assert.Nil(t, resp.GetError())
+			assert.Equal(t, uint64(i), resp.GetResult().GetOffset())
+		})
+	}
+
+	wg.Wait()
+
+	require.Len(t, api.sent(), 1)
+	assert.Len(t, api.sent()[0], 5)
+
+	resp, err := c.publish(t.Context(), &v1Client.PublishRequest{Channel: "news", Data: []byte("bad")})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(107), resp.GetError().GetCode())
+
+	bresp, err := c.broadcast(t.Context(), &v1Client.BroadcastRequest{Channels: []string{"a", "b"}, Data: []byte("x")})
+	require.NoError(t, err)
+	assert.NotNil(t, bresp.GetResult())
+}
+
+func TestBatcherMaxSizeAndOrder(t *testing.T) {
+	api := &fakeBatchAPI{}
+	// the window never ends, the batches are sent by size
+	b := startBatcher(&Batching{Window: time.Hour, MaxSize: 3}, api.batch)
+	t.Cleanup(b.close)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 6; i++ {
+		call := &batchCall{cmd: &v1Client.Command{Publish: &v1Client.PublishRequest{Channel: "news", Data: []byte(strconv.Itoa(i))}}, done: make(chan batchResult, 1)}
+		// queued one by one, as a single publisher would do
+		b.calls <- call
+
+		wg.Go(func() {
+			res := <-call.done
+			assert.NoError(t, res.err)
+		})
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, [][]string{{"1", "2", "3"}, {"4", "5", "6"}}, api.sent())
+}
+
+func TestBatcherError(t *testing.T) {
+	api := &fakeBatchAPI{err: errors.New("unavailable")}
+	c := &client{batcher: startBatcher(&Batching{Window: time.Millisecond, MaxSize: 10}, api.batch)}
+	t.Cleanup(c.batcher.close)
+
+	_, err := c.publish(t.Context(), &v1Client.PublishRequest{Channel: "news", Data: []byte("1")})
+	require.EqualError(t, err, "unavailable")
+}
+
+func TestBatcherClose(t *testing.T) {
+	api := &fakeBatchAPI{}
+	b := startBatcher(&Batching{Window: time.Hour, MaxSize: 10}, api.batch)
+
+	call := &batchCall{cmd: &v1Client.Command{Publish: &v1Client.PublishRequest{Channel: "news", Data: []byte("1")}}, done: make(chan batchResult, 1)}
+	b.calls <- call
+
+	// the pending command is sent on close
+	b.close()
+	require.NoError(t, (<-call.done).err)
+	assert.Equal(t, [][]string{{"1"}}, api.sent())
+
+	_, err := b.do(t.Context(), &v1Client.Command{Publish: &v1Client.PublishRequest{Channel: "news"}})
+	require.Error(t, err)
+}
+
+func TestBatcherTimeout(t *testing.T) {
+	hanging := func(ctx context.Context, _ *v1Client.BatchRequest) (*v1Client.BatchResponse, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	c := &client{batcher: startBatcher(&Batching{Window: time.Millisecond, MaxSize: 10, Timeout: time.Millisecond * 20}, hanging)}
+	t.Cleanup(c.batcher.close)
+
+	// the caller without a deadline is released by the batch timeout
+	_, err := c.publish(context.Background(), &v1Client.PublishRequest{Channel: "news", Data: []byte("1")})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
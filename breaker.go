@@ -0,0 +1,282 @@
+package centrifuge
+
+import (
+	"context"
+	stderr "errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// circuit breaker states, the values are exported by the state gauge
+const (
+	circuitClosed   int = 0
+	circuitOpen     int = 1
+	circuitHalfOpen int = 2
+)
+
+func circuitStateName(state int) string {
+	switch state {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker is a circuit breaker of a single proxy type. It counts worker-level failures only (see
+// isWorkerError), a Centrifugo error or disconnect returned by the application, an application
+// (soft job) error or an invalid worker response is a successful call. The calls cancelled by the
+// caller, e.g. a disconnected client, are not counted.
+type breaker struct {
+	mu      sync.Mutex
+	typ     string
+	cfg     *CircuitBreaker
+	log     *slog.Logger
+	metrics *proxyMetrics
+
+	state int
+	// closed state counters
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+	// open state
+	openedAt time.Time
+	// half-open state
+	probes    int
+	successes int
+
+	now func() time.Time
+}
+
+func newBreaker(typ string, cfg *CircuitBreaker, metrics *proxyMetrics, log *slog.Logger) *breaker {
+	b := &breaker{
+		typ:     typ,
+		cfg:     cfg,
+		log:     log,
+		metrics: metrics,
+		now:     time.Now,
+	}
+
+	b.windowStart = b.now()
+
+	return b
+}
+
+// allow reports whether the call may reach the workers.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+
+		b.transition(circuitHalfOpen)
+		b.probes++
+
+		return true
+	case circuitHalfOpen:
+		if b.probes >= b.cfg.HalfOpenProbes {
+			return false
+		}
+
+		b.probes++
+
+		return true
+	default:
+		return true
+	}
+}
+
+// done records the result of an allowed call.
+func (b *breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	switch b.state {
+	case circuitHalfOpen:
+		if failed {
+			b.transition(circuitOpen)
+			return
+		}
+
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenProbes {
+			b.transition(circuitClosed)
+		}
+	case circuitClosed:
+		if now.Sub(b.windowStart) >= b.cfg.Window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+
+		b.requests++
+		if !failed {
+			b.consecutive = 0
+			return
+		}
+
+		b.failures++
+		b.consecutive++
+
+		if b.cfg.Failures > 0 && b.consecutive >= b.cfg.Failures {
+			b.transition(circuitOpen)
+			return
+		}
+
+		if b.cfg.FailureRate > 0 && b.requests >= b.cfg.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= b.cfg.FailureRate {
+			b.transition(circuitOpen)
+		}
+	default:
+		// the result of a call allowed before the circuit opened
+	}
+}
+
+// release returns the half-open probe of an allowed call which was not counted.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// transition must be called with the lock held.
+func (b *breaker) transition(state int) {
+	from := b.state
+	b.state = state
+
+	now := b.now()
+	b.consecutive, b.requests, b.failures = 0, 0, 0
+	b.windowStart = now
+	b.probes, b.successes = 0, 0
+
+	if state == circuitOpen {
+		b.openedAt = now
+	}
+
+	if b.metrics != nil {
+		b.metrics.circuitState.WithLabelValues(b.typ).Set(float64(state))
+		b.metrics.circuitTransitions.WithLabelValues(b.typ, circuitStateName(state)).Inc()
+	}
+
+	args := []any{"type", b.typ, "from", circuitStateName(from), "to", circuitStateName(state)}
+	if state == circuitOpen {
+		b.log.Warn("proxy circuit breaker opened", args...)
+		return
+	}
+
+	b.log.Info("proxy circuit breaker state changed", args...)
+}
+
+// breakers is the circuit breaker middleware, it guards the proxy types with a configured circuit breaker.
+type breakers struct {
+	byType  map[string]*breaker
+	metrics *proxyMetrics
+}
+
+func newBreakers(proxies map[string]*ProxyConfig, metrics *proxyMetrics, log *slog.Logger) *breakers {
+	bs := &breakers{
+		byType:  make(map[string]*breaker),
+		metrics: metrics,
+	}
+
+	for typ, pc := range proxies {
+		if pc.CircuitBreaker != nil {
+			bs.byType[typ] = newBreaker(typ, pc.CircuitBreaker, metrics, log)
+		}
+	}
+
+	return bs
+}
+
+func (bs *breakers) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		b, ok := bs.byType[req.Type]
+		if !ok {
+			return next(ctx, req)
+		}
+
+		if !b.allow() {
+			if bs.metrics != nil {
+				bs.metrics.circuitRejected.WithLabelValues(req.Type).Inc()
+			}
+
+			return fallback(req, b.cfg.Fallback)
+		}
+
+		resp, err := next(ctx, req)
+		if err != nil && stderr.Is(ctx.Err(), context.Canceled) {
+			// the caller went away, the call says nothing about the workers
+			b.release()
+
+			return resp, err
+		}
+
+		b.done(isWorkerError(err))
+
+		return resp, err
+	}
+}
+
+// fallback returns the configured response of an open circuit, Unavailable when there is none.
+func fallback(req *Request, fb *Fallback) (proto.Message, error) {
+	req.fallback = true
+
+	if fb == nil {
+		return nil, status.Errorf(codes.Unavailable, "%s proxy circuit breaker is open", req.Type)
+	}
+
+	resp := req.NewResponse()
+	m := resp.ProtoReflect()
+
+	switch {
+	case fb.Disconnect != nil:
+		fd := m.Descriptor().Fields().ByName("disconnect")
+		if fd == nil {
+			return nil, errors.Errorf("%s proxy response does not support disconnect", req.Type)
+		}
+
+		d := m.NewField(fd).Message()
+		setField(d, "code", protoreflect.ValueOfUint32(fb.Disconnect.Code))
+		setField(d, "reason", protoreflect.ValueOfString(fb.Disconnect.Reason))
+		m.Set(fd, protoreflect.ValueOfMessage(d))
+	case fb.Error != nil:
+		fd := m.Descriptor().Fields().ByName("error")
+		e := m.NewField(fd).Message()
+		setField(e, "code", protoreflect.ValueOfUint32(fb.Error.Code))
+		setField(e, "message", protoreflect.ValueOfString(fb.Error.Message))
+		setField(e, "temporary", protoreflect.ValueOfBool(fb.Error.Temporary))
+		m.Set(fd, protoreflect.ValueOfMessage(e))
+	case fb.Allow:
+		// an empty result allows the call
+		fd := m.Descriptor().Fields().ByName("result")
+		if fd != nil {
+			m.Set(fd, protoreflect.ValueOfMessage(m.NewField(fd).Message()))
+		}
+	}
+
+	return resp, nil
+}
+
+func setField(m protoreflect.Message, name protoreflect.Name, v protoreflect.Value) {
+	if fd := m.Descriptor().Fields().ByName(name); fd != nil {
+		m.Set(fd, v)
+	}
+}
@@ -0,0 +1,197 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	rrerrors "github.com/roadrunner-server/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// flakyWorker fails while failing is set and counts the calls reaching it.
+type flakyWorker struct {
+	failing bool
+	calls   int
+}
+
+func (f *flakyWorker) handle(context.Context, *Request) (proto.Message, error) {
+	f.calls++
+	if f.failing {
+		return nil, &workerError{err: errors.New("worker empty response")}
+	}
+
+	return &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1"}}, nil
+}
+
+func newTestBreakers(t *testing.T, cb *CircuitBreaker) (*breakers, *time.Time) {
+	t.Helper()
+
+	require.NoError(t, cb.InitDefaults())
+
+	bs := newBreakers(map[string]*ProxyConfig{connectType: {CircuitBreaker: cb}}, newProxyMetrics(), testLogger())
+
+	now := time.Now()
+	bs.byType[connectType].now = func() time.Time { return now }
+
+	return bs, &now
+}
+
+func connectRequest() *Request {
+	return &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{}, response: &centrifugov1.ConnectResponse{}}
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	bs, now := newTestBreakers(t, &CircuitBreaker{
+		Failures:    3,
+		OpenTimeout: time.Second,
+		Fallback:    &Fallback{Error: &FallbackError{Code: 503, Message: "unavailable", Temporary: true}},
+	})
+
+	w := &flakyWorker{failing: true}
+	h := bs.Middleware(w.handle)
+
+	for range 3 {
+		_, err := h(t.Context(), connectRequest())
+		require.Error(t, err)
+	}
+
+	// open, the fallback is served without touching the worker
+	resp, err := h(t.Context(), connectRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 3, w.calls)
+	assert.Equal(t, uint32(503), resp.(*centrifugov1.ConnectResponse).GetError().GetCode())
+	assert.True(t, resp.(*centrifugov1.ConnectResponse).GetError().GetTemporary())
+	assert.InDelta(t, 1, counterValue(t, bs.metrics.circuitRejected.WithLabelValues(connectType)), 0)
+
+	// half-open, the failed probe opens the circuit again
+	*now = now.Add(time.Second)
+	_, err = h(t.Context(), connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, 4, w.calls)
+	assert.Equal(t, circuitOpen, bs.byType[connectType].state)
+
+	// half-open, the successful probe closes the circuit
+	w.failing = false
+	*now = now.Add(time.Second)
+	_, err = h(t.Context(), connectRequest())
+	require.NoError(t, err)
+	assert.Equal(t, circuitClosed, bs.byType[connectType].state)
+	assert.InDelta(t, 2, counterValue(t, bs.metrics.circuitTransitions.WithLabelValues(connectType, "open")), 0)
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	bs, _ := newTestBreakers(t, &CircuitBreaker{FailureRate: 0.5, MinRequests: 4})
+
+	w := &flakyWorker{}
+	h := bs.Middleware(w.handle)
+
+	for i := range 4 {
+		w.failing = i%2 == 1
+		_, _ = h(t.Context(), connectRequest())
+	}
+
+	// 2 of 4 calls failed
+	_, err := h(t.Context(), connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 4, w.calls)
+}
+
+func TestBreakerWindow(t *testing.T) {
+	bs, now := newTestBreakers(t, &CircuitBreaker{FailureRate: 0.5, MinRequests: 2, Window: time.Second})
+
+	w := &flakyWorker{failing: true}
+	h := bs.Middleware(w.handle)
+
+	_, _ = h(t.Context(), connectRequest())
+
+	// the failure falls out of the window
+	*now = now.Add(time.Second)
+	w.failing = false
+	_, _ = h(t.Context(), connectRequest())
+	_, _ = h(t.Context(), connectRequest())
+
+	assert.Equal(t, circuitClosed, bs.byType[connectType].state)
+}
+
+func TestBreakerIgnoresDenials(t *testing.T) {
+	bs, _ := newTestBreakers(t, &CircuitBreaker{Failures: 1})
+
+	h := bs.Middleware(func(context.Context, *Request) (proto.Message, error) {
+		return &centrifugov1.ConnectResponse{Error: &centrifugov1.Error{Code: 103}}, nil
+	})
+
+	for range 3 {
+		resp, err := h(t.Context(), connectRequest())
+		require.NoError(t, err)
+		assert.Equal(t, uint32(103), resp.(*centrifugov1.ConnectResponse).GetError().GetCode())
+	}
+
+	assert.Equal(t, circuitClosed, bs.byType[connectType].state)
+}
+
+func TestBreakerIgnoresCancelled(t *testing.T) {
+	bs, now := newTestBreakers(t, &CircuitBreaker{Failures: 1, OpenTimeout: time.Second, HalfOpenProbes: 1})
+
+	w := &flakyWorker{failing: true}
+	h := bs.Middleware(w.handle)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	// the client disconnected while the worker was busy
+	_, err := h(ctx, connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, circuitClosed, bs.byType[connectType].state)
+
+	// not a worker failure
+	_, err = bs.Middleware(func(context.Context, *Request) (proto.Message, error) {
+		return nil, rrerrors.E(rrerrors.Op("exec"), rrerrors.SoftJob, errors.New("application error"))
+	})(t.Context(), connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, circuitClosed, bs.byType[connectType].state)
+
+	_, err = h(t.Context(), connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, circuitOpen, bs.byType[connectType].state)
+
+	// the cancelled probe does not hold the half-open slot
+	*now = now.Add(time.Second)
+	_, _ = h(ctx, connectRequest())
+	w.failing = false
+	_, err = h(t.Context(), connectRequest())
+	require.NoError(t, err)
+	assert.Equal(t, circuitClosed, bs.byType[connectType].state)
+}
+
+func TestBreakerNotConfigured(t *testing.T) {
+	bs := newBreakers(map[string]*ProxyConfig{rpcType: {}}, nil, testLogger())
+
+	w := &flakyWorker{failing: true}
+	h := bs.Middleware(w.handle)
+
+	for range 10 {
+		_, _ = h(t.Context(), connectRequest())
+	}
+
+	assert.Equal(t, 10, w.calls)
+}
+
+func TestFallback(t *testing.T) {
+	resp, err := fallback(connectRequest(), &Fallback{Disconnect: &FallbackDisconnect{Code: 4000, Reason: "maintenance"}})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4000), resp.(*centrifugov1.ConnectResponse).GetDisconnect().GetCode())
+	assert.Equal(t, "maintenance", resp.(*centrifugov1.ConnectResponse).GetDisconnect().GetReason())
+
+	resp, err = fallback(connectRequest(), &Fallback{Allow: true})
+	require.NoError(t, err)
+	assert.Equal(t, resultAllowed, outcome(resp, nil))
+	assert.NotNil(t, resp.(*centrifugov1.ConnectResponse).GetResult())
+}
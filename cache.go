@@ -0,0 +1,297 @@
+package centrifuge
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// extension of the persisted publication files
+const cacheFileExt string = ".pub"
+
+// maxCacheFileName keeps the file names within the 255 bytes limit of the common file systems
+const maxCacheFileName int = 200
+
+// publishFunc sends a publication to the Centrifugo server API
+type publishFunc func(ctx context.Context, req *v1Client.PublishRequest) error
+
+// cacheEntry is the last publication of a channel
+type cacheEntry struct {
+	pub      *v1Client.PublishRequest
+	storedAt time.Time
+	// persisted publication file, empty if kept in memory only
+	file string
+	// element of the eviction order, the value is the channel
+	elem *list.Element
+}
+
+// cacheRecovery populates the Centrifugo channel cache on NotifyCacheEmpty. It remembers the last
+// publication per channel sent through the plugin Publish RPC and publishes it again when Centrifugo
+// reports the cache of the channel is empty. The channels without a known publication go to the workers.
+// At most maxEntries publications are kept for ttl, the oldest ones are evicted with their files.
+type cacheRecovery struct {
+	// guards the entries and their files
+	mu         sync.Mutex
+	log        *slog.Logger
+	channels   []string
+	dir        string
+	timeout    time.Duration
+	maxEntries int
+	ttl        time.Duration
+	last       map[string]*cacheEntry
+	// channels from the most to the least recently stored
+	order   *list.List
+	publish publishFunc
+
+	now func() time.Time
+}
+
+func newCacheRecovery(cfg *CacheRecovery, publish publishFunc, log *slog.Logger) (*cacheRecovery, error) {
+	const op = errors.Op("centrifuge_cache_recovery")
+
+	c := &cacheRecovery{
+		log:        log,
+		channels:   cfg.Channels,
+		dir:        cfg.Dir,
+		timeout:    cfg.Timeout,
+		maxEntries: cfg.MaxEntries,
+		ttl:        cfg.TTL,
+		last:       make(map[string]*cacheEntry),
+		order:      list.New(),
+		publish:    publish,
+		now:        time.Now,
+	}
+
+	if c.dir == "" {
+		return c, nil
+	}
+
+	err := os.MkdirAll(c.dir, 0o750)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = c.load()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return c, nil
+}
+
+// load reads the persisted publications, the unreadable files are skipped. The files of the expired
+// publications and of the channels no longer recovered are removed.
+func (c *cacheRecovery) load() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	loaded := make([]*cacheEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), cacheFileExt) {
+			continue
+		}
+
+		file := filepath.Join(c.dir, e.Name())
+
+		info, err := e.Info()
+		if err != nil {
+			c.log.Warn("failed to read the persisted publication", "file", e.Name(), "error", err)
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			c.log.Warn("failed to read the persisted publication", "file", e.Name(), "error", err)
+			continue
+		}
+
+		pub := &v1Client.PublishRequest{}
+		err = proto.Unmarshal(data, pub)
+		if err != nil || pub.GetChannel() == "" {
+			c.log.Warn("skipping a malformed persisted publication", "file", e.Name(), "error", err)
+			continue
+		}
+
+		loaded = append(loaded, &cacheEntry{pub: pub, storedAt: info.ModTime(), file: file})
+	}
+
+	// the oldest first, so the newest publication of a channel wins and ends up in front
+	slices.SortFunc(loaded, func(a, b *cacheEntry) int {
+		return a.storedAt.Compare(b.storedAt)
+	})
+
+	for _, e := range loaded {
+		if !c.recovered(e.pub.GetChannel()) {
+			c.removeFile(e.file)
+			continue
+		}
+
+		if old, ok := c.last[e.pub.GetChannel()]; ok {
+			c.remove(old)
+		}
+
+		e.elem = c.order.PushFront(e.pub.GetChannel())
+		c.last[e.pub.GetChannel()] = e
+	}
+
+	c.evict(c.now())
+
+	c.log.Debug("persisted publications loaded", "channels", len(c.last))
+
+	return nil
+}
+
+// recovered reports whether the cache of the channel is populated by the plugin.
+func (c *cacheRecovery) recovered(channel string) bool {
+	for _, p := range c.channels {
+		if ok, _ := path.Match(p, channel); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// store remembers a successful publication. The publications without history do not populate
+// the cache and are ignored.
+func (c *cacheRecovery) store(pub *v1Client.PublishRequest) {
+	if pub.GetSkipHistory() || !c.recovered(pub.GetChannel()) {
+		return
+	}
+
+	pub = proto.CloneOf(pub)
+	// the key would make Centrifugo drop the re-publication
+	pub.IdempotencyKey = ""
+
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.last[pub.GetChannel()]
+	if ok {
+		e.pub, e.storedAt = pub, now
+		c.order.MoveToFront(e.elem)
+	} else {
+		e = &cacheEntry{pub: pub, storedAt: now}
+		if c.dir != "" {
+			e.file = filepath.Join(c.dir, cacheFileName(pub.GetChannel()))
+		}
+
+		e.elem = c.order.PushFront(pub.GetChannel())
+		c.last[pub.GetChannel()] = e
+	}
+
+	c.evict(now)
+
+	if e.file == "" {
+		return
+	}
+
+	err := persist(c.dir, e.file, pub)
+	if err != nil {
+		c.log.Warn("failed to persist the publication", "channel", pub.GetChannel(), "error", err)
+	}
+}
+
+func (c *cacheRecovery) lastPublication(channel string) *v1Client.PublishRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evict(c.now())
+
+	if e, ok := c.last[channel]; ok {
+		return e.pub
+	}
+
+	return nil
+}
+
+// evict removes the expired entries and the least recently stored ones above the limit. The entries
+// are ordered by the store time, so the expired ones are at the back. It must be called with the lock held.
+func (c *cacheRecovery) evict(now time.Time) {
+	for back := c.order.Back(); back != nil; back = c.order.Back() {
+		e := c.last[back.Value.(string)]
+		if len(c.last) <= c.maxEntries && now.Sub(e.storedAt) < c.ttl {
+			return
+		}
+
+		c.remove(e)
+	}
+}
+
+// remove drops the entry and its file. It must be called with the lock held.
+func (c *cacheRecovery) remove(e *cacheEntry) {
+	c.order.Remove(e.elem)
+	delete(c.last, e.pub.GetChannel())
+
+	if e.file != "" {
+		c.removeFile(e.file)
+	}
+}
+
+func (c *cacheRecovery) removeFile(file string) {
+	err := os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		c.log.Warn("failed to remove the persisted publication", "file", file, "error", err)
+	}
+}
+
+// cacheFileName returns the file name of the persisted publication of the channel: the base64 encoded
+// channel, or its SHA-256 hash if the encoded channel is too long for a file name.
+func cacheFileName(channel string) string {
+	name := base64.RawURLEncoding.EncodeToString([]byte(channel))
+	if len(name) > maxCacheFileName {
+		// the dot is not in the base64 alphabet, the hashed names do not collide with the encoded ones
+		sum := sha256.Sum256([]byte(channel))
+		name = "sha256." + hex.EncodeToString(sum[:])
+	}
+
+	return name + cacheFileExt
+}
+
+func (c *cacheRecovery) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		m, ok := req.Message.(*centrifugov1.NotifyCacheEmptyRequest)
+		if !ok || !c.recovered(m.GetChannel()) {
+			return next(ctx, req)
+		}
+
+		pub := c.lastPublication(m.GetChannel())
+		if pub == nil {
+			return next(ctx, req)
+		}
+
+		pctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+
+		err := c.publish(pctx, pub)
+		if err != nil {
+			c.log.Warn("failed to populate the channel cache, passing to the workers", "request_id", req.ID, "channel", m.GetChannel(), "error", err)
+			return next(ctx, req)
+		}
+
+		c.log.Debug("channel cache populated", "request_id", req.ID, "channel", m.GetChannel())
+
+		return &centrifugov1.NotifyCacheEmptyResponse{
+			Result: &centrifugov1.NotifyCacheEmptyResult{Populated: true},
+		}, nil
+	}
+}
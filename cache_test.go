@@ -0,0 +1,199 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakePublisher struct {
+	published []*v1Client.PublishRequest
+	err       error
+}
+
+func (f *fakePublisher) publish(_ context.Context, req *v1Client.PublishRequest) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	f.published = append(f.published, req)
+
+	return nil
+}
+
+func cacheEmptyRequest(channel string) *Request {
+	return &Request{
+		Type:     notifyCacheEmptyType,
+		Message:  &centrifugov1.NotifyCacheEmptyRequest{Channel: channel},
+		response: &centrifugov1.NotifyCacheEmptyResponse{},
+	}
+}
+
+func TestCacheRecovery(t *testing.T) {
+	pub := &fakePublisher{}
+	c, err := newCacheRecovery(&CacheRecovery{Channels: []string{"news:*"}, Timeout: time.Second, MaxEntries: 10, TTL: time.Hour}, pub.publish, testLogger())
+	require.NoError(t, err)
+
+	var workerCalls int
+	h := c.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		workerCalls++
+		return req.NewResponse(), nil
+	})
+
+	c.store(&v1Client.PublishRequest{Channel: "news:1", Data: []byte(`{"v":1}`), IdempotencyKey: "k1"})
+	c.store(&v1Client.PublishRequest{Channel: "news:1", Data: []byte(`{"v":2}`)})
+	// no history, nothing to populate the cache with
+	c.store(&v1Client.PublishRequest{Channel: "news:2", Data: []byte(`{"v":1}`), SkipHistory: true})
+	// not a recovered channel
+	c.store(&v1Client.PublishRequest{Channel: "chat:1", Data: []byte(`{"v":1}`)})
+
+	resp, err := h(t.Context(), cacheEmptyRequest("news:1"))
+	require.NoError(t, err)
+	assert.True(t, resp.(*centrifugov1.NotifyCacheEmptyResponse).GetResult().GetPopulated())
+	require.Len(t, pub.published, 1)
+	assert.JSONEq(t, `{"v":2}`, string(pub.published[0].GetData()))
+	assert.Empty(t, pub.published[0].GetIdempotencyKey())
+
+	_, err = h(t.Context(), cacheEmptyRequest("news:2"))
+	require.NoError(t, err)
+	_, err = h(t.Context(), cacheEmptyRequest("chat:1"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, workerCalls)
+
+	// the workers get the call when the publication failed
+	pub.err = errors.New("unavailable")
+	resp, err = h(t.Context(), cacheEmptyRequest("news:1"))
+	require.NoError(t, err)
+	assert.False(t, resp.(*centrifugov1.NotifyCacheEmptyResponse).GetResult().GetPopulated())
+	assert.Equal(t, 3, workerCalls)
+}
+
+func TestCacheRecoveryPersistence(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &CacheRecovery{Channels: []string{"news/*", "chat"}, Dir: dir, Timeout: time.Second, MaxEntries: 10, TTL: time.Hour}
+
+	c, err := newCacheRecovery(cfg, (&fakePublisher{}).publish, testLogger())
+	require.NoError(t, err)
+
+	c.store(&v1Client.PublishRequest{Channel: "news/sport:1", Data: []byte(`{"v":1}`)})
+	c.store(&v1Client.PublishRequest{Channel: "news/sport:1", Data: []byte(`{"v":2}`)})
+	c.store(&v1Client.PublishRequest{Channel: "chat", Data: []byte(`{"v":3}`)})
+	require.NoError(t, os.WriteFile(dir+"/broken"+cacheFileExt, []byte("not a publication"), 0o600))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	pub := &fakePublisher{}
+	c, err = newCacheRecovery(cfg, pub.publish, testLogger())
+	require.NoError(t, err)
+
+	h := c.Middleware(func(context.Context, *Request) (proto.Message, error) {
+		t.Fatal("the workers should not be called")
+		return nil, nil
+	})
+
+	_, err = h(t.Context(), cacheEmptyRequest("news/sport:1"))
+	require.NoError(t, err)
+	_, err = h(t.Context(), cacheEmptyRequest("chat"))
+	require.NoError(t, err)
+
+	require.Len(t, pub.published, 2)
+	assert.JSONEq(t, `{"v":2}`, string(pub.published[0].GetData()))
+	assert.JSONEq(t, `{"v":3}`, string(pub.published[1].GetData()))
+}
+
+func TestCacheRecoveryEviction(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newCacheRecovery(&CacheRecovery{Channels: []string{"*"}, Dir: dir, Timeout: time.Second, MaxEntries: 2, TTL: time.Minute}, (&fakePublisher{}).publish, testLogger())
+	require.NoError(t, err)
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	files := func() int {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+
+		return len(entries)
+	}
+
+	c.store(&v1Client.PublishRequest{Channel: "a", Data: []byte(`{}`)})
+	now = now.Add(time.Second)
+	c.store(&v1Client.PublishRequest{Channel: "b", Data: []byte(`{}`)})
+	now = now.Add(time.Second)
+	// a is stored again, b is the least recently stored one
+	c.store(&v1Client.PublishRequest{Channel: "a", Data: []byte(`{}`)})
+	now = now.Add(time.Second)
+	c.store(&v1Client.PublishRequest{Channel: "c", Data: []byte(`{}`)})
+
+	assert.NotNil(t, c.lastPublication("a"))
+	assert.Nil(t, c.lastPublication("b"))
+	assert.NotNil(t, c.lastPublication("c"))
+	assert.Equal(t, 2, files())
+
+	// a expires first
+	now = now.Add(time.Minute - time.Second)
+	assert.Nil(t, c.lastPublication("a"))
+	assert.NotNil(t, c.lastPublication("c"))
+	assert.Equal(t, 1, files())
+
+	now = now.Add(time.Second)
+	assert.Nil(t, c.lastPublication("c"))
+	assert.Zero(t, files())
+}
+
+func TestCacheRecoveryLoadExpired(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &CacheRecovery{Channels: []string{"news:*"}, Dir: dir, Timeout: time.Second, MaxEntries: 10, TTL: time.Minute}
+
+	c, err := newCacheRecovery(cfg, (&fakePublisher{}).publish, testLogger())
+	require.NoError(t, err)
+	c.store(&v1Client.PublishRequest{Channel: "news:1", Data: []byte(`{}`)})
+	c.store(&v1Client.PublishRequest{Channel: "news:2", Data: []byte(`{}`)})
+
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(dir+"/"+cacheFileName("news:1"), old, old))
+
+	// the channel is no longer recovered
+	cfg.Channels = []string{"news:1", "news:3"}
+	c, err = newCacheRecovery(cfg, (&fakePublisher{}).publish, testLogger())
+	require.NoError(t, err)
+	assert.Nil(t, c.lastPublication("news:1"))
+	assert.Nil(t, c.lastPublication("news:2"))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCacheFileName(t *testing.T) {
+	assert.Equal(t, "bmV3czox"+cacheFileExt, cacheFileName("news:1"))
+
+	long := strings.Repeat("channel:", 100)
+	name := cacheFileName(long)
+	assert.True(t, strings.HasPrefix(name, "sha256."))
+	assert.LessOrEqual(t, len(name), 255)
+	assert.NotEqual(t, name, cacheFileName(long+"1"))
+
+	// the long channel is persisted and loaded back
+	dir := t.TempDir()
+	cfg := &CacheRecovery{Channels: []string{"*"}, Dir: dir, Timeout: time.Second, MaxEntries: 10, TTL: time.Hour}
+
+	c, err := newCacheRecovery(cfg, (&fakePublisher{}).publish, testLogger())
+	require.NoError(t, err)
+	c.store(&v1Client.PublishRequest{Channel: long, Data: []byte(`{}`)})
+
+	c, err = newCacheRecovery(cfg, (&fakePublisher{}).publish, testLogger())
+	require.NoError(t, err)
+	assert.NotNil(t, c.lastPublication(long))
+}
@@ -0,0 +1,155 @@
+package centrifuge
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// RRCanary is set in the environment of the canary pool workers
+const RRCanary string = "RR_CENTRIFUGE_CANARY"
+
+// pool labels of the per-pool metrics
+const (
+	stablePool string = "stable"
+	canaryPool string = "canary"
+)
+
+// sticky assignment keys
+const (
+	stickyUser   string = "user"
+	stickyClient string = "client"
+)
+
+// canary splits the proxy calls between the stable pool (next handler) and the canary pool.
+// The canary weight is the percentage of the calls sent to the canary pool and can be changed at runtime.
+type canary struct {
+	log     *slog.Logger
+	metrics *proxyMetrics
+	// exec sends the request to the canary pool
+	exec     Handler
+	types    []string
+	stickyBy string
+
+	weight atomic.Int32
+	// set once the canary pool replaced the stable one
+	promoted atomic.Bool
+}
+
+func newCanary(cfg *Canary, exec Handler, metrics *proxyMetrics, log *slog.Logger) *canary {
+	c := &canary{
+		log:      log,
+		metrics:  metrics,
+		exec:     exec,
+		types:    cfg.Types,
+		stickyBy: cfg.StickyBy,
+	}
+
+	c.setWeight(cfg.Weight)
+
+	return c
+}
+
+func (c *canary) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		h, pool := next, stablePool
+		if c.routed(req) {
+			h, pool = c.exec, canaryPool
+		}
+
+		resp, err := h(ctx, req)
+		if c.metrics != nil {
+			c.metrics.poolRequests.WithLabelValues(pool, req.Type, outcome(resp, err)).Inc()
+		}
+
+		return resp, err
+	}
+}
+
+// routed reports whether the request goes to the canary pool. With sticky assignment
+// the same user or client always lands in the same pool for a given weight.
+func (c *canary) routed(req *Request) bool {
+	if c.promoted.Load() || !slices.Contains(c.types, req.Type) {
+		return false
+	}
+
+	w := c.weight.Load()
+	switch w {
+	case 0:
+		return false
+	case 100:
+		return true
+	}
+
+	if key := c.stickyKey(req); key != "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+
+		return int32(h.Sum32()%100) < w //nolint:gosec
+	}
+
+	return rand.Int32N(100) < w //nolint:gosec
+}
+
+// stickyKey returns the user or client ID of the request, the connect request carries no user yet.
+func (c *canary) stickyKey(req *Request) string {
+	switch c.stickyBy {
+	case stickyUser:
+		if m, ok := req.Message.(interface{ GetUser() string }); ok && m.GetUser() != "" {
+			return m.GetUser()
+		}
+		// connect, fall back to the client ID
+		if m, ok := req.Message.(interface{ GetClient() string }); ok {
+			return m.GetClient()
+		}
+	case stickyClient:
+		if m, ok := req.Message.(interface{ GetClient() string }); ok {
+			return m.GetClient()
+		}
+	}
+
+	return ""
+}
+
+func (c *canary) setWeight(w int) {
+	c.weight.Store(int32(w)) //nolint:gosec
+
+	if c.metrics != nil {
+		c.metrics.canaryWeight.Set(float64(w))
+	}
+}
+
+// promote sends every call to the stable pool, which is the former canary pool after the swap.
+func (c *canary) promote() {
+	c.promoted.Store(true)
+	c.setWeight(0)
+}
+
+// CanaryWeightRequest changes the share of the proxy calls sent to the canary pool.
+type CanaryWeightRequest struct {
+	// Weight is the percentage of the calls sent to the canary pool, 0-100.
+	Weight int `json:"weight"`
+}
+
+// CanaryRequest is the empty request of the canary promote and status RPC methods.
+type CanaryRequest struct{}
+
+// CanaryState is the current canary routing state.
+type CanaryState struct {
+	Weight   int    `json:"weight"`
+	StickyBy string `json:"sticky_by"`
+	Promoted bool   `json:"promoted"`
+}
+
+func (c *canary) state() *CanaryState {
+	return &CanaryState{
+		Weight:   int(c.weight.Load()),
+		StickyBy: c.stickyBy,
+		Promoted: c.promoted.Load(),
+	}
+}
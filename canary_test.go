@@ -0,0 +1,112 @@
+package centrifuge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// poolHandler answers with the pool name as the connect user.
+func poolHandler(pool string) Handler {
+	return func(context.Context, *Request) (proto.Message, error) {
+		return &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: pool}}, nil
+	}
+}
+
+func served(t *testing.T, h Handler, req *Request) string {
+	t.Helper()
+
+	resp, err := h(t.Context(), req)
+	require.NoError(t, err)
+
+	return resp.(*centrifugov1.ConnectResponse).GetResult().GetUser()
+}
+
+func TestCanaryWeight(t *testing.T) {
+	metrics := newProxyMetrics()
+	c := newCanary(&Canary{Types: proxyTypes()}, poolHandler(canaryPool), metrics, testLogger())
+	h := c.Middleware(poolHandler(stablePool))
+
+	req := &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{Client: "c1"}}
+	assert.Equal(t, stablePool, served(t, h, req))
+
+	c.setWeight(100)
+	assert.Equal(t, canaryPool, served(t, h, req))
+
+	assert.InDelta(t, 1, counterValue(t, metrics.poolRequests.WithLabelValues(stablePool, connectType, resultAllowed)), 0)
+	assert.InDelta(t, 1, counterValue(t, metrics.poolRequests.WithLabelValues(canaryPool, connectType, resultAllowed)), 0)
+	assert.Equal(t, 100, c.state().Weight)
+}
+
+func TestCanaryTypes(t *testing.T) {
+	c := newCanary(&Canary{Weight: 100, Types: []string{subscribeType}}, poolHandler(canaryPool), nil, testLogger())
+	h := c.Middleware(poolHandler(stablePool))
+
+	assert.Equal(t, stablePool, served(t, h, &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{}}))
+	assert.Equal(t, canaryPool, served(t, h, &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{}}))
+}
+
+func TestCanarySticky(t *testing.T) {
+	c := newCanary(&Canary{Weight: 50, StickyBy: stickyUser, Types: proxyTypes()}, poolHandler(canaryPool), nil, testLogger())
+	h := c.Middleware(poolHandler(stablePool))
+
+	pools := map[string]int{}
+	for i := range 100 {
+		user := fmt.Sprintf("user-%d", i)
+		first := served(t, h, &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{User: user}})
+
+		// the same user always lands in the same pool
+		for range 5 {
+			assert.Equal(t, first, served(t, h, &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{User: user}}))
+		}
+
+		pools[first]++
+	}
+
+	assert.Positive(t, pools[stablePool])
+	assert.Positive(t, pools[canaryPool])
+
+	// connect carries no user, the client ID is used instead
+	assert.Equal(t, "c1", c.stickyKey(&Request{Type: connectType, Message: &centrifugov1.ConnectRequest{Client: "c1"}}))
+}
+
+func TestPluginPromoteCanary(t *testing.T) {
+	stable, cp := &fakePool{}, &fakePool{}
+
+	p := &Plugin{log: testLogger(), pool: stable, canaryPool: cp}
+	p.proxy = newProxy(testLogger(), newPoolMuWrapper(stable, &sync.RWMutex{}), newRedactor(nil))
+	p.canary = newCanary(&Canary{Weight: 10, Types: proxyTypes()}, poolHandler(canaryPool), nil, testLogger())
+
+	st, err := p.setCanaryWeight(30)
+	require.NoError(t, err)
+	assert.Equal(t, 30, st.Weight)
+
+	_, err = p.setCanaryWeight(101)
+	require.Error(t, err)
+
+	st, err = p.promoteCanary(t.Context())
+	require.NoError(t, err)
+	assert.True(t, st.Promoted)
+	assert.Equal(t, 0, st.Weight)
+
+	assert.Same(t, cp, p.pool)
+	assert.Same(t, cp, p.proxy.pw.pool)
+	assert.Nil(t, p.canaryPool)
+	assert.True(t, stable.destroyed)
+
+	_, err = p.promoteCanary(t.Context())
+	require.Error(t, err)
+	_, err = p.setCanaryWeight(50)
+	require.Error(t, err)
+}
+
+func TestPluginCanaryNotConfigured(t *testing.T) {
+	_, err := (&Plugin{}).canaryState()
+	require.Error(t, err)
+}
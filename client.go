@@ -1,6 +1,7 @@
 package centrifuge
 
 import (
+	"context"
 	"crypto/tls"
 	"log/slog"
 	"sync"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/cenkalti/backoff/v4"
 	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"github.com/roadrunner-server/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
@@ -23,6 +25,10 @@ type client struct {
 	addr     string
 	tls      *TLS
 	compress bool
+	// nil unless the batching is configured
+	batcher *batcher
+	// Centrifugo HTTP API, nil for the gRPC transport
+	httpAPI *HTTPAPI
 
 	centrifugoClient v1Client.CentrifugoApiClient
 }
@@ -57,6 +63,21 @@ func (c *client) connect() error {
 		}
 	}
 
+	if c.httpAPI != nil {
+		var tlscfg *tls.Config
+		if c.tls != nil {
+			tlscfg = &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS12,
+			}
+		}
+
+		c.centrifugoClient = v1Client.NewCentrifugoApiClient(newHTTPConn(c.httpAPI, tlscfg))
+		c.log.Debug("using the centrifugo http api", "address", c.httpAPI.Address)
+
+		return nil
+	}
+
 	operation := func() error {
 		if c.tls != nil {
 			tlscfg := &tls.Config{
@@ -102,3 +123,51 @@ func (c *client) client() v1Client.CentrifugoApiClient {
 
 	return c.centrifugoClient
 }
+
+// batch sends the batch directly, it is the transport of the batcher.
+func (c *client) batch(ctx context.Context, req *v1Client.BatchRequest) (*v1Client.BatchResponse, error) {
+	cl := c.client()
+	if cl == nil {
+		return nil, errors.Str("RoadRunner is not ready yet, try in a few seconds")
+	}
+
+	return cl.Batch(ctx, req)
+}
+
+// publish sends the publication, batched with other commands if the batching is configured.
+func (c *client) publish(ctx context.Context, req *v1Client.PublishRequest) (*v1Client.PublishResponse, error) {
+	if c.batcher == nil {
+		cl := c.client()
+		if cl == nil {
+			return nil, errors.Str("RoadRunner is not ready yet, try in a few seconds")
+		}
+
+		return cl.Publish(ctx, req)
+	}
+
+	r, err := c.batcher.do(ctx, &v1Client.Command{Publish: req})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1Client.PublishResponse{Error: r.GetError(), Result: r.GetPublish()}, nil
+}
+
+// broadcast sends the broadcast, batched with other commands if the batching is configured.
+func (c *client) broadcast(ctx context.Context, req *v1Client.BroadcastRequest) (*v1Client.BroadcastResponse, error) {
+	if c.batcher == nil {
+		cl := c.client()
+		if cl == nil {
+			return nil, errors.Str("RoadRunner is not ready yet, try in a few seconds")
+		}
+
+		return cl.Broadcast(ctx, req)
+	}
+
+	r, err := c.batcher.do(ctx, &v1Client.Command{Broadcast: req})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1Client.BroadcastResponse{Error: r.GetError(), Result: r.GetBroadcast()}, nil
+}
@@ -3,7 +3,11 @@ package centrifuge
 import (
 	stderrors "errors"
 	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/roadrunner-server/errors"
 	"github.com/roadrunner-server/pool/v2/pool"
@@ -12,16 +16,330 @@ import (
 type Config struct {
 	// host + port
 	ProxyAddress string `mapstructure:"proxy_address"`
+	// Listeners are additional proxy listeners: TCP or unix sockets, with optional TLS
+	Listeners []*Listener `mapstructure:"listeners"`
+	// HTTPProxy accepts Centrifugo HTTP proxy requests
+	HTTPProxy *HTTPProxy `mapstructure:"http_proxy"`
 	// host + port
 	GrpcAPIAddress string `mapstructure:"grpc_api_address"`
 	UseCompressor  bool   `mapstructure:"use_compressor"`
 	Version        string `mapstructure:"version"`
 	Name           string `mapstructure:"name"`
 	TLS            *TLS   `mapstructure:"tls"`
+	// Transport of the Centrifugo server API: grpc (default) or http
+	Transport string `mapstructure:"transport"`
+	// HTTPAPI is the Centrifugo HTTP API, used with the http transport
+	HTTPAPI *HTTPAPI `mapstructure:"http_api"`
+	// Batching sends the publish and broadcast RPC calls to Centrifugo in batches
+	Batching *Batching `mapstructure:"batching"`
+	// Idempotency adds idempotency keys to the publish and broadcast RPC calls and retries them
+	Idempotency *Idempotency `mapstructure:"idempotency"`
+	// Middleware is the list of proxy middleware names, the first one is the outermost
+	Middleware []string `mapstructure:"middleware"`
+	// AccessLog enables one log record per proxy call
+	AccessLog *AccessLog `mapstructure:"access_log"`
+	// Auth enables the `auth` middleware, it checks the credentials Centrifugo sends with the proxy calls
+	Auth *Auth `mapstructure:"auth"`
+	// ACL enables the `acl` middleware, it limits the channels the clients may subscribe and publish to
+	ACL *ACL `mapstructure:"acl"`
+	// Redact masks credentials in logs, payload dumps and recorded traffic
+	Redact *Redact `mapstructure:"redact"`
+	// ContextVersion is the payload context format sent to the workers: 1 (gRPC metadata, default) or 2 (typed context)
+	ContextVersion int `mapstructure:"context_version"`
+	// Record writes the proxy traffic to a local file for debugging and replay
+	Record *Record `mapstructure:"record"`
+	// Shadow mirrors a part of the proxy traffic to a candidate worker pool
+	Shadow *Shadow `mapstructure:"shadow"`
+	// Canary splits the proxy traffic between the stable and a canary worker pool
+	Canary *Canary `mapstructure:"canary"`
+	// GrpcServer holds the proxy gRPC server options
+	GrpcServer *GrpcServer `mapstructure:"grpc_server"`
+	// Handshake asks the workers for the supported proxy types on start
+	Handshake *Handshake `mapstructure:"handshake"`
+	// CacheRecovery populates the channel cache on NotifyCacheEmpty with the last publication
+	CacheRecovery *CacheRecovery `mapstructure:"cache_recovery"`
+	// Outbox is the queue of the asynchronous publish and broadcast RPC methods
+	Outbox *Outbox `mapstructure:"outbox"`
+	// Tokens signs the connection and subscription tokens issued over RPC
+	Tokens *Tokens `mapstructure:"tokens"`
+	// EnabledProxies are the proxy types served by the workers, all types by default
+	EnabledProxies []string `mapstructure:"enabled_proxies"`
+	// Proxies holds per proxy type settings, keyed by the proxy type, e.g. connect or rpc
+	Proxies map[string]*ProxyConfig `mapstructure:"proxies"`
 
 	Pool *pool.Config `mapstructure:"pool"`
 }
 
+type Auth struct {
+	// Metadata is the gRPC metadata key holding the token, authorization by default
+	Metadata string `mapstructure:"metadata"`
+	// Tokens accepted from Centrifugo, an optional `Bearer ` prefix is ignored
+	Tokens []string `mapstructure:"tokens"`
+}
+
+type ACL struct {
+	// Subscribe are the glob patterns of the channels allowed in the subscribe and subrefresh calls
+	Subscribe []string `mapstructure:"subscribe"`
+	// Publish are the glob patterns of the channels allowed in the publish calls
+	Publish []string `mapstructure:"publish"`
+}
+
+type AccessLog struct {
+	// Fields to include in each record, all supported fields by default
+	Fields []string `mapstructure:"fields"`
+}
+
+type Record struct {
+	// Path to the recording file
+	Path string `mapstructure:"path"`
+	// MaxSize in megabytes after which the file is rotated, 100 by default
+	MaxSize int `mapstructure:"max_size"`
+	// MaxFiles is the number of rotated files to keep, 5 by default
+	MaxFiles int `mapstructure:"max_files"`
+}
+
+type Shadow struct {
+	// Percentage of the proxy calls to mirror, 0-100
+	Percentage float64 `mapstructure:"percentage"`
+	// Types are the mirrored proxy types, connect, refresh, subscribe and subrefresh by default
+	Types []string `mapstructure:"types"`
+	// Timeout of a single shadow call, 10s by default
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxInFlight limits the concurrent shadow calls, the rest is not mirrored, 100 by default
+	MaxInFlight int `mapstructure:"max_in_flight"`
+	// Pool is the shadow worker pool configuration
+	Pool *pool.Config `mapstructure:"pool"`
+}
+
+type Canary struct {
+	// Weight is the percentage of the proxy calls sent to the canary pool, 0-100
+	Weight int `mapstructure:"weight"`
+	// StickyBy keeps a user or client on the same pool: user, client or empty for a random split
+	StickyBy string `mapstructure:"sticky_by"`
+	// Types are the routed proxy types, all types by default
+	Types []string `mapstructure:"types"`
+	// Pool is the canary worker pool configuration
+	Pool *pool.Config `mapstructure:"pool"`
+}
+
+type GrpcServer struct {
+	// MaxRecvMsgSize in megabytes, 4 by default (gRPC default)
+	MaxRecvMsgSize int `mapstructure:"max_recv_msg_size"`
+	// MaxSendMsgSize in megabytes, unlimited by default (gRPC default)
+	MaxSendMsgSize int `mapstructure:"max_send_msg_size"`
+	// MaxConcurrentStreams per connection
+	MaxConcurrentStreams uint32 `mapstructure:"max_concurrent_streams"`
+	// ConnectionTimeout is the timeout of the connection setup, including the TLS handshake
+	ConnectionTimeout time.Duration `mapstructure:"connection_timeout"`
+	// Keepalive are the server keepalive parameters
+	Keepalive *Keepalive `mapstructure:"keepalive"`
+	// KeepaliveEnforcement is the keepalive policy enforced on the clients
+	KeepaliveEnforcement *KeepaliveEnforcement `mapstructure:"keepalive_enforcement"`
+	// Reflection registers the gRPC server reflection service
+	Reflection bool `mapstructure:"reflection"`
+}
+
+type Keepalive struct {
+	MaxConnectionIdle     time.Duration `mapstructure:"max_connection_idle"`
+	MaxConnectionAge      time.Duration `mapstructure:"max_connection_age"`
+	MaxConnectionAgeGrace time.Duration `mapstructure:"max_connection_age_grace"`
+	// Time after which the server pings an idle client, 2h by default
+	Time time.Duration `mapstructure:"time"`
+	// Timeout waiting for the ping ack, 20s by default
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type KeepaliveEnforcement struct {
+	// MinTime a client should wait between the pings, 5m by default
+	MinTime time.Duration `mapstructure:"min_time"`
+	// PermitWithoutStream allows the pings without active streams
+	PermitWithoutStream bool `mapstructure:"permit_without_stream"`
+}
+
+type Handshake struct {
+	// Timeout of the handshake, 10s by default
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Interval of the worker checks, the handshake is repeated once the workers of a pool changed, 5s by default
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+type CacheRecovery struct {
+	// Channels are the glob patterns of the recovered channels, required
+	Channels []string `mapstructure:"channels"`
+	// Dir persists the last publications, kept in memory only if empty
+	Dir string `mapstructure:"dir"`
+	// Timeout of the re-publication, 10s by default
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxEntries is the maximum number of the kept publications, the least recently stored ones are
+	// evicted, 10000 by default
+	MaxEntries int `mapstructure:"max_entries"`
+	// TTL of a kept publication, 1h by default
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+type Outbox struct {
+	// Size is the maximum number of the queued commands, 10000 by default
+	Size int `mapstructure:"size"`
+	// Dir persists the queued commands, kept in memory only if empty
+	Dir string `mapstructure:"dir"`
+	// Timeout of a single delivery attempt, 10s by default
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxAttempts is the number of delivery attempts before the command is dropped, 10 by default, -1 retries until delivered
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// InitialBackoff is the delay before the first retry, 100ms by default
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	// MaxBackoff caps the exponential retry delay, 30s by default
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+}
+
+type Tokens struct {
+	// Keys sign and verify the tokens, the retired keys are kept in the list while rotating
+	Keys []*TokenKey `mapstructure:"keys"`
+	// ActiveKey is the kid of the key signing the new tokens, the first key by default
+	ActiveKey string `mapstructure:"active_key"`
+	// TTL of the tokens issued without an explicit lifetime, 1h by default
+	TTL time.Duration `mapstructure:"ttl"`
+	// Issuer is the iss claim, omitted if empty
+	Issuer string `mapstructure:"issuer"`
+	// Audience is the aud claim, omitted if empty
+	Audience string `mapstructure:"audience"`
+}
+
+type TokenKey struct {
+	// ID is the kid header of the signed tokens, required when several keys are configured
+	ID string `mapstructure:"kid"`
+	// Algorithm is one of HS256, HS384, HS512, RS256, RS384, RS512, ES256, ES384, ES512
+	Algorithm string `mapstructure:"algorithm"`
+	// Secret is the HMAC secret or the PEM encoded key, a public key only verifies the tokens
+	Secret string `mapstructure:"secret"`
+	// File holds the HMAC secret, the PEM encoded key or certificate
+	File string `mapstructure:"file"`
+}
+
+type ProxyConfig struct {
+	// SlowThreshold reports worker executions taking longer than this, 0 disables the check
+	SlowThreshold time.Duration `mapstructure:"slow_threshold"`
+	// CircuitBreaker stops sending the calls to failing workers
+	CircuitBreaker *CircuitBreaker `mapstructure:"circuit_breaker"`
+	// Retry sends the call again when the worker failed, idempotent proxy types only
+	Retry *Retry `mapstructure:"retry"`
+	// DefaultResponse is served when the proxy type is not enabled, gRPC Unimplemented if not set
+	DefaultResponse *Fallback `mapstructure:"default_response"`
+}
+
+type Retry struct {
+	// MaxAttempts including the first one, 3 by default
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// Budget is the number of retries earned by each call, 0.1 by default (one retry per ten calls)
+	Budget float64 `mapstructure:"budget"`
+	// BudgetBurst is the maximum number of retries the budget can hold, 10 by default
+	BudgetBurst int `mapstructure:"budget_burst"`
+	// Backoff between the attempts
+	Backoff time.Duration `mapstructure:"backoff"`
+}
+
+type CircuitBreaker struct {
+	// Failures is the number of consecutive worker failures opening the circuit, the errors of the
+	// application, the full queue and the calls cancelled by the caller are not worker failures
+	Failures int `mapstructure:"failures"`
+	// FailureRate opens the circuit when the share of failed calls within the window reaches it, 0-1
+	FailureRate float64 `mapstructure:"failure_rate"`
+	// MinRequests within the window before the failure rate is evaluated, 10 by default
+	MinRequests int `mapstructure:"min_requests"`
+	// Window of the failure rate, 10s by default
+	Window time.Duration `mapstructure:"window"`
+	// OpenTimeout is the time the circuit stays open before probing the workers, 30s by default
+	OpenTimeout time.Duration `mapstructure:"open_timeout"`
+	// HalfOpenProbes is the number of successful probes closing the circuit, 1 by default
+	HalfOpenProbes int `mapstructure:"half_open_probes"`
+	// Fallback is the response served while the circuit is open, gRPC Unavailable if not set
+	Fallback *Fallback `mapstructure:"fallback"`
+}
+
+// Fallback is a proxy response served without a worker, exactly one option should be set
+type Fallback struct {
+	// Allow answers with an empty result
+	Allow      bool                `mapstructure:"allow"`
+	Error      *FallbackError      `mapstructure:"error"`
+	Disconnect *FallbackDisconnect `mapstructure:"disconnect"`
+}
+
+type FallbackError struct {
+	Code      uint32 `mapstructure:"code"`
+	Message   string `mapstructure:"message"`
+	Temporary bool   `mapstructure:"temporary"`
+}
+
+type FallbackDisconnect struct {
+	Code   uint32 `mapstructure:"code"`
+	Reason string `mapstructure:"reason"`
+}
+
+type Redact struct {
+	// Metadata keys to mask in addition to authorization, cookie and set-cookie
+	Metadata []string `mapstructure:"metadata"`
+	// Paths are dot-separated JSON paths inside the request data to mask, '*' matches any key or array element
+	Paths []string `mapstructure:"paths"`
+}
+
+type Listener struct {
+	// Address is tcp://host:port or unix:///path/to/socket
+	Address string `mapstructure:"address"`
+	// Permissions of the unix socket file, octal, e.g. 0660
+	Permissions string `mapstructure:"permissions"`
+	// TLS enables TLS on the listener
+	TLS *ListenerTLS `mapstructure:"tls"`
+}
+
+type HTTPProxy struct {
+	// Address is tcp://host:port or unix:///path/to/socket
+	Address string `mapstructure:"address"`
+	// MaxRequestSize in megabytes, 4 by default
+	MaxRequestSize int `mapstructure:"max_request_size"`
+}
+
+type ListenerTLS struct {
+	Key  string `mapstructure:"key"`
+	Cert string `mapstructure:"cert"`
+	// ClientCA enables the client certificate verification (mTLS)
+	ClientCA string `mapstructure:"client_ca"`
+}
+
+type HTTPAPI struct {
+	// Address is the Centrifugo HTTP server URL, e.g. http://127.0.0.1:8000, the methods are called at /api/<method>
+	Address string `mapstructure:"address"`
+	// Key is the Centrifugo API key sent in the X-API-Key header
+	Key string `mapstructure:"key"`
+	// MaxIdleConns is the number of the kept-alive connections, 100 by default
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+	// IdleTimeout closes the kept-alive connections idle for this long, 90s by default
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+}
+
+type Idempotency struct {
+	// Window keeps the responses of the successful calls with a caller or derived key, a repeated call
+	// with the same key within the window gets the kept response without reaching Centrifugo, 1m by default.
+	// Centrifugo itself drops the publications repeating a key for its idempotent result TTL (5m by
+	// default), regardless of the window.
+	Window time.Duration `mapstructure:"window"`
+	// DeriveKeys derives the key of a call without a caller key from the channels and the data, so the
+	// identical publications are dropped. Otherwise such a call gets a random key, only its retries share it.
+	DeriveKeys bool `mapstructure:"derive_keys"`
+	// Retries is the number of the repeated calls after a transport error, 2 by default, -1 disables them
+	Retries int `mapstructure:"retries"`
+	// Backoff is the delay between the repeated calls, 100ms by default
+	Backoff time.Duration `mapstructure:"backoff"`
+}
+
+type Batching struct {
+	// Window is the time the commands are collected for, 5ms by default
+	Window time.Duration `mapstructure:"window"`
+	// MaxSize sends the batch before the window ends once it holds this many commands, 100 by default
+	MaxSize int `mapstructure:"max_size"`
+	// Timeout of a single batch request, 10s by default
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
 type TLS struct {
 	Key  string `mapstructure:"key"`
 	Cert string `mapstructure:"cert"`
@@ -55,23 +373,530 @@ func (c *Config) InitDefaults() error {
 	}
 	c.Pool.InitDefaults()
 
-	if c.TLS != nil { //nolint:nestif
-		if _, err := os.Stat(c.TLS.Key); err != nil {
-			if stderrors.Is(err, os.ErrNotExist) {
-				return errors.E(op, errors.Errorf("key file '%s' does not exists", c.TLS.Key))
+	if c.AccessLog != nil {
+		if len(c.AccessLog.Fields) == 0 {
+			c.AccessLog.Fields = accessLogFields()
+		}
+
+		for _, f := range c.AccessLog.Fields {
+			if !slices.Contains(accessLogFields(), f) && f != fieldMetadata && f != fieldData && f != fieldWorkerPID {
+				return errors.E(op, errors.Errorf("unknown access log field '%s'", f))
 			}
+		}
+	}
+
+	if c.Auth != nil {
+		if c.Auth.Metadata == "" {
+			c.Auth.Metadata = "authorization"
+		}
+
+		if len(c.Auth.Tokens) == 0 {
+			return errors.E(op, errors.Str("auth requires at least one token"))
+		}
+	}
+
+	if c.ACL != nil {
+		if len(c.ACL.Subscribe) == 0 && len(c.ACL.Publish) == 0 {
+			return errors.E(op, errors.Str("acl requires subscribe or publish channel patterns"))
+		}
 
+		for _, ch := range slices.Concat(c.ACL.Subscribe, c.ACL.Publish) {
+			if _, err := path.Match(ch, ""); err != nil {
+				return errors.E(op, errors.Errorf("invalid acl channel pattern '%s': %v", ch, err))
+			}
+		}
+	}
+
+	if c.Record != nil {
+		if c.Record.Path == "" {
+			return errors.E(op, errors.Str("record path should not be empty"))
+		}
+
+		if c.Record.MaxSize == 0 {
+			c.Record.MaxSize = 100
+		}
+
+		if c.Record.MaxFiles == 0 {
+			c.Record.MaxFiles = 5
+		}
+	}
+
+	if c.Shadow != nil {
+		err := c.Shadow.InitDefaults()
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	if c.Canary != nil {
+		err := c.Canary.InitDefaults()
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	if c.GrpcServer != nil {
+		err := c.GrpcServer.InitDefaults()
+		if err != nil {
 			return errors.E(op, err)
 		}
+	}
+
+	switch c.ContextVersion {
+	case 0:
+		c.ContextVersion = contextV1
+	case contextV1, contextV2:
+	default:
+		return errors.E(op, errors.Errorf("unsupported context_version %d, supported: 1, 2", c.ContextVersion))
+	}
+
+	if c.Handshake != nil {
+		if c.Handshake.Timeout == 0 {
+			c.Handshake.Timeout = time.Second * 10
+		}
+
+		if c.Handshake.Interval == 0 {
+			c.Handshake.Interval = time.Second * 5
+		}
+
+		if c.Handshake.Timeout < 0 || c.Handshake.Interval < 0 {
+			return errors.E(op, errors.Str("handshake timeout and interval should not be negative"))
+		}
+	}
 
-		if _, err := os.Stat(c.TLS.Cert); err != nil {
-			if stderrors.Is(err, os.ErrNotExist) {
-				return errors.E(op, errors.Errorf("cert file '%s' does not exists", c.TLS.Cert))
+	switch c.Transport {
+	case "":
+		c.Transport = transportGRPC
+	case transportGRPC:
+	case transportHTTP:
+		if c.HTTPAPI == nil || c.HTTPAPI.Address == "" {
+			return errors.E(op, errors.Str("http_api.address should be set for the http transport"))
+		}
+
+		if c.HTTPAPI.MaxIdleConns == 0 {
+			c.HTTPAPI.MaxIdleConns = 100
+		}
+
+		if c.HTTPAPI.IdleTimeout == 0 {
+			c.HTTPAPI.IdleTimeout = time.Second * 90
+		}
+	default:
+		return errors.E(op, errors.Errorf("unknown transport '%s', supported: grpc, http", c.Transport))
+	}
+
+	if c.Batching != nil {
+		if c.Batching.Window == 0 {
+			c.Batching.Window = time.Millisecond * 5
+		}
+
+		if c.Batching.MaxSize == 0 {
+			c.Batching.MaxSize = 100
+		}
+
+		if c.Batching.Timeout == 0 {
+			c.Batching.Timeout = time.Second * 10
+		}
+
+		if c.Batching.Window < 0 || c.Batching.MaxSize < 0 || c.Batching.Timeout < 0 {
+			return errors.E(op, errors.Str("batching window, max_size and timeout should not be negative"))
+		}
+	}
+
+	if c.Idempotency != nil {
+		if c.Idempotency.Window == 0 {
+			c.Idempotency.Window = time.Minute
+		}
+
+		if c.Idempotency.Retries == 0 {
+			c.Idempotency.Retries = 2
+		}
+
+		if c.Idempotency.Backoff == 0 {
+			c.Idempotency.Backoff = time.Millisecond * 100
+		}
+
+		if c.Idempotency.Window < 0 || c.Idempotency.Retries < -1 {
+			return errors.E(op, errors.Str("idempotency window should not be negative, retries should be positive or -1"))
+		}
+	}
+
+	if c.CacheRecovery != nil {
+		if c.CacheRecovery.Timeout == 0 {
+			c.CacheRecovery.Timeout = time.Second * 10
+		}
+
+		if c.CacheRecovery.MaxEntries == 0 {
+			c.CacheRecovery.MaxEntries = 10000
+		}
+
+		if c.CacheRecovery.TTL == 0 {
+			c.CacheRecovery.TTL = time.Hour
+		}
+
+		if c.CacheRecovery.MaxEntries < 0 || c.CacheRecovery.TTL < 0 {
+			return errors.E(op, errors.Str("cache recovery max_entries and ttl should not be negative"))
+		}
+
+		// every channel would keep its last publication
+		if len(c.CacheRecovery.Channels) == 0 {
+			return errors.E(op, errors.Str("cache recovery requires channel patterns"))
+		}
+
+		for _, ch := range c.CacheRecovery.Channels {
+			if _, err := path.Match(ch, ""); err != nil {
+				return errors.E(op, errors.Errorf("invalid cache recovery channel pattern '%s': %v", ch, err))
 			}
+		}
+	}
+
+	if c.Outbox != nil {
+		if c.Outbox.Size == 0 {
+			c.Outbox.Size = 10000
+		}
+
+		if c.Outbox.Timeout == 0 {
+			c.Outbox.Timeout = time.Second * 10
+		}
+
+		if c.Outbox.InitialBackoff == 0 {
+			c.Outbox.InitialBackoff = time.Millisecond * 100
+		}
 
+		if c.Outbox.MaxBackoff == 0 {
+			c.Outbox.MaxBackoff = time.Second * 30
+		}
+
+		if c.Outbox.MaxAttempts == 0 {
+			c.Outbox.MaxAttempts = 10
+		}
+
+		if c.Outbox.Size < 0 || c.Outbox.MaxAttempts < -1 {
+			return errors.E(op, errors.Str("outbox size should not be negative, max_attempts should be positive or -1"))
+		}
+	}
+
+	if c.Tokens != nil {
+		err := c.Tokens.InitDefaults()
+		if err != nil {
 			return errors.E(op, err)
 		}
 	}
 
+	if len(c.EnabledProxies) == 0 {
+		c.EnabledProxies = proxyTypes()
+	}
+
+	for _, typ := range c.EnabledProxies {
+		if !slices.Contains(proxyTypes(), typ) {
+			return errors.E(op, errors.Errorf("unknown enabled proxy type '%s', supported: %s", typ, strings.Join(proxyTypes(), ", ")))
+		}
+	}
+
+	for typ, pc := range c.Proxies {
+		if !slices.Contains(proxyTypes(), typ) {
+			return errors.E(op, errors.Errorf("unknown proxy type '%s', supported: %s", typ, strings.Join(proxyTypes(), ", ")))
+		}
+
+		if pc == nil {
+			c.Proxies[typ] = &ProxyConfig{}
+			continue
+		}
+
+		if pc.CircuitBreaker != nil {
+			err := pc.CircuitBreaker.InitDefaults()
+			if err != nil {
+				return errors.E(op, errors.Errorf("%s proxy: %v", typ, err))
+			}
+		}
+
+		if pc.DefaultResponse != nil {
+			err := pc.DefaultResponse.validate()
+			if err != nil {
+				return errors.E(op, errors.Errorf("%s proxy default_response: %v", typ, err))
+			}
+		}
+
+		if pc.Retry != nil {
+			if !slices.Contains(idempotentTypes(), typ) {
+				return errors.E(op, errors.Errorf("%s proxy: retry is supported for the idempotent proxy types only: %s", typ, strings.Join(idempotentTypes(), ", ")))
+			}
+
+			err := pc.Retry.InitDefaults()
+			if err != nil {
+				return errors.E(op, errors.Errorf("%s proxy: %v", typ, err))
+			}
+		}
+	}
+
+	if c.HTTPProxy != nil {
+		if c.HTTPProxy.Address == "" {
+			return errors.E(op, errors.Str("http_proxy address should not be empty"))
+		}
+
+		if c.HTTPProxy.MaxRequestSize == 0 {
+			c.HTTPProxy.MaxRequestSize = 4
+		}
+	}
+
+	for i, l := range c.Listeners {
+		if l == nil || l.Address == "" {
+			return errors.E(op, errors.Errorf("listener %d: address should not be empty", i))
+		}
+
+		if l.Permissions != "" {
+			if _, err := strconv.ParseUint(l.Permissions, 8, 32); err != nil {
+				return errors.E(op, errors.Errorf("listener %s: invalid permissions '%s', octal file mode expected", l.Address, l.Permissions))
+			}
+		}
+
+		if l.TLS == nil {
+			continue
+		}
+
+		err := checkFile("key", l.TLS.Key)
+		if err == nil {
+			err = checkFile("cert", l.TLS.Cert)
+		}
+		if err == nil && l.TLS.ClientCA != "" {
+			err = checkFile("client_ca", l.TLS.ClientCA)
+		}
+		if err != nil {
+			return errors.E(op, errors.Errorf("listener %s: %v", l.Address, err))
+		}
+	}
+
+	if c.TLS != nil {
+		err := checkFile("key", c.TLS.Key)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		err = checkFile("cert", c.TLS.Cert)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	return nil
+}
+
+func checkFile(kind, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		if stderrors.Is(err, os.ErrNotExist) {
+			return errors.Errorf("%s file '%s' does not exists", kind, path)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *Shadow) InitDefaults() error {
+	if s.Percentage < 0 || s.Percentage > 100 {
+		return errors.Errorf("shadow percentage should be between 0 and 100, got %v", s.Percentage)
+	}
+
+	if len(s.Types) == 0 {
+		// side effect free proxy types
+		s.Types = []string{connectType, refreshType, subscribeType, subRefreshType}
+	}
+
+	for _, typ := range s.Types {
+		if !slices.Contains(proxyTypes(), typ) {
+			return errors.Errorf("unknown shadow proxy type '%s', supported: %s", typ, strings.Join(proxyTypes(), ", "))
+		}
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = time.Second * 10
+	}
+
+	if s.MaxInFlight == 0 {
+		s.MaxInFlight = 100
+	}
+
+	if s.Pool == nil {
+		s.Pool = &pool.Config{}
+	}
+	s.Pool.InitDefaults()
+
+	return nil
+}
+
+func (c *Canary) InitDefaults() error {
+	if c.Weight < 0 || c.Weight > 100 {
+		return errors.Errorf("canary weight should be between 0 and 100, got %d", c.Weight)
+	}
+
+	switch c.StickyBy {
+	case "", stickyUser, stickyClient:
+	default:
+		return errors.Errorf("unsupported canary sticky_by '%s', supported: user, client", c.StickyBy)
+	}
+
+	if len(c.Types) == 0 {
+		c.Types = proxyTypes()
+	}
+
+	for _, typ := range c.Types {
+		if !slices.Contains(proxyTypes(), typ) {
+			return errors.Errorf("unknown canary proxy type '%s', supported: %s", typ, strings.Join(proxyTypes(), ", "))
+		}
+	}
+
+	if c.Pool == nil {
+		c.Pool = &pool.Config{}
+	}
+	c.Pool.InitDefaults()
+
+	return nil
+}
+
+func (cb *CircuitBreaker) InitDefaults() error {
+	if cb.FailureRate < 0 || cb.FailureRate > 1 {
+		return errors.Errorf("circuit breaker failure_rate should be between 0 and 1, got %v", cb.FailureRate)
+	}
+
+	if cb.Failures == 0 && cb.FailureRate == 0 {
+		cb.Failures = 5
+	}
+
+	if cb.MinRequests == 0 {
+		cb.MinRequests = 10
+	}
+
+	if cb.Window == 0 {
+		cb.Window = time.Second * 10
+	}
+
+	if cb.OpenTimeout == 0 {
+		cb.OpenTimeout = time.Second * 30
+	}
+
+	if cb.HalfOpenProbes == 0 {
+		cb.HalfOpenProbes = 1
+	}
+
+	if cb.Fallback != nil {
+		return cb.Fallback.validate()
+	}
+
+	return nil
+}
+
+func (f *Fallback) validate() error {
+	n := 0
+	if f.Allow {
+		n++
+	}
+	if f.Error != nil {
+		n++
+	}
+	if f.Disconnect != nil {
+		n++
+	}
+
+	if n != 1 {
+		return errors.Str("fallback should set exactly one of allow, error or disconnect")
+	}
+
+	return nil
+}
+
+func (r *Retry) InitDefaults() error {
+	if r.MaxAttempts < 0 || r.Budget < 0 || r.BudgetBurst < 0 || r.Backoff < 0 {
+		return errors.Str("retry settings should not be negative")
+	}
+
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 3
+	}
+
+	if r.Budget == 0 {
+		r.Budget = 0.1
+	}
+
+	if r.BudgetBurst == 0 {
+		r.BudgetBurst = 10
+	}
+
+	return nil
+}
+
+func (g *GrpcServer) InitDefaults() error {
+	if g.MaxRecvMsgSize < 0 || g.MaxSendMsgSize < 0 || g.ConnectionTimeout < 0 {
+		return errors.Str("grpc_server message sizes and connection timeout should not be negative")
+	}
+
+	if g.Keepalive != nil {
+		if g.Keepalive.Time == 0 {
+			g.Keepalive.Time = defaultKeepaliveTime
+		}
+
+		if g.Keepalive.Timeout == 0 {
+			g.Keepalive.Timeout = defaultKeepaliveTimeout
+		}
+	}
+
+	if g.KeepaliveEnforcement != nil && g.KeepaliveEnforcement.MinTime == 0 {
+		g.KeepaliveEnforcement.MinTime = defaultKeepaliveMinTime
+	}
+
+	return nil
+}
+
+func (t *Tokens) InitDefaults() error {
+	if len(t.Keys) == 0 {
+		return errors.Str("tokens should have at least one key")
+	}
+
+	if t.TTL < 0 {
+		return errors.Str("tokens ttl should not be negative")
+	}
+
+	if t.TTL == 0 {
+		t.TTL = time.Hour
+	}
+
+	kids := make(map[string]struct{}, len(t.Keys))
+	for i, k := range t.Keys {
+		if k == nil {
+			return errors.Errorf("token key %d is empty", i)
+		}
+
+		if !slices.Contains(tokenAlgorithms(), k.Algorithm) {
+			return errors.Errorf("unsupported token key algorithm '%s', supported: %s", k.Algorithm, strings.Join(tokenAlgorithms(), ", "))
+		}
+
+		if (k.Secret == "") == (k.File == "") {
+			return errors.Errorf("token key '%s' should set exactly one of secret or file", k.ID)
+		}
+
+		if k.File != "" {
+			err := checkFile("token key", k.File)
+			if err != nil {
+				return err
+			}
+		}
+
+		if k.ID == "" && len(t.Keys) > 1 {
+			return errors.Str("token keys should have a kid when several keys are configured")
+		}
+
+		if _, ok := kids[k.ID]; ok {
+			return errors.Errorf("duplicate token key kid '%s'", k.ID)
+		}
+		kids[k.ID] = struct{}{}
+	}
+
+	if t.ActiveKey == "" {
+		t.ActiveKey = t.Keys[0].ID
+	}
+
+	if _, ok := kids[t.ActiveKey]; !ok {
+		return errors.Errorf("active token key '%s' is not configured", t.ActiveKey)
+	}
+
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -97,3 +98,296 @@ func TestConfigTLSValid(t *testing.T) {
 
 	require.NoError(t, cfg.InitDefaults())
 }
+
+func TestConfigAccessLogDefaults(t *testing.T) {
+	cfg := &Config{AccessLog: &AccessLog{}}
+	require.NoError(t, cfg.InitDefaults())
+
+	assert.Equal(t, accessLogFields(), cfg.AccessLog.Fields)
+	// opt-in, the worker has to report it
+	assert.NotContains(t, cfg.AccessLog.Fields, fieldWorkerPID)
+
+	cfg = &Config{AccessLog: &AccessLog{Fields: []string{fieldWorkerPID}}}
+	require.NoError(t, cfg.InitDefaults())
+}
+
+func TestConfigAccessLogUnknownField(t *testing.T) {
+	cfg := &Config{AccessLog: &AccessLog{Fields: []string{fieldUser, "password"}}}
+
+	err := cfg.InitDefaults()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown access log field 'password'")
+}
+
+func TestConfigAccessLogOptionalFields(t *testing.T) {
+	cfg := &Config{AccessLog: &AccessLog{Fields: []string{fieldMetadata, fieldData}}}
+	require.NoError(t, cfg.InitDefaults())
+}
+
+func TestConfigProxies(t *testing.T) {
+	cfg := &Config{Proxies: map[string]*ProxyConfig{
+		connectType: {SlowThreshold: time.Second},
+		rpcType:     nil,
+	}}
+	require.NoError(t, cfg.InitDefaults())
+
+	assert.Equal(t, time.Second, cfg.Proxies[connectType].SlowThreshold)
+	assert.NotNil(t, cfg.Proxies[rpcType])
+}
+
+func TestConfigProxiesUnknownType(t *testing.T) {
+	cfg := &Config{Proxies: map[string]*ProxyConfig{"sub_refresh": {}}}
+
+	err := cfg.InitDefaults()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown proxy type 'sub_refresh'")
+}
+
+func TestConfigContextVersion(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, contextV1, cfg.ContextVersion)
+
+	cfg = &Config{ContextVersion: contextV2}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, contextV2, cfg.ContextVersion)
+
+	cfg = &Config{ContextVersion: 3}
+	err := cfg.InitDefaults()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported context_version 3")
+}
+
+func TestConfigRecord(t *testing.T) {
+	cfg := &Config{Record: &Record{Path: "proxy.rec"}}
+	require.NoError(t, cfg.InitDefaults())
+
+	assert.Equal(t, 100, cfg.Record.MaxSize)
+	assert.Equal(t, 5, cfg.Record.MaxFiles)
+
+	cfg = &Config{Record: &Record{}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigShadow(t *testing.T) {
+	cfg := &Config{Shadow: &Shadow{Percentage: 10}}
+	require.NoError(t, cfg.InitDefaults())
+
+	assert.Equal(t, []string{connectType, refreshType, subscribeType, subRefreshType}, cfg.Shadow.Types)
+	assert.Equal(t, time.Second*10, cfg.Shadow.Timeout)
+	assert.Equal(t, 100, cfg.Shadow.MaxInFlight)
+	assert.NotNil(t, cfg.Shadow.Pool)
+
+	cfg = &Config{Shadow: &Shadow{Percentage: 101}}
+	require.Error(t, cfg.InitDefaults())
+
+	cfg = &Config{Shadow: &Shadow{Percentage: 10, Types: []string{"sub_refresh"}}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigCanary(t *testing.T) {
+	cfg := &Config{Canary: &Canary{Weight: 10, StickyBy: stickyUser}}
+	require.NoError(t, cfg.InitDefaults())
+
+	assert.Equal(t, proxyTypes(), cfg.Canary.Types)
+	assert.NotNil(t, cfg.Canary.Pool)
+
+	cfg = &Config{Canary: &Canary{Weight: -1}}
+	require.Error(t, cfg.InitDefaults())
+
+	cfg = &Config{Canary: &Canary{StickyBy: "session"}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigCircuitBreaker(t *testing.T) {
+	cfg := &Config{Proxies: map[string]*ProxyConfig{connectType: {CircuitBreaker: &CircuitBreaker{}}}}
+	require.NoError(t, cfg.InitDefaults())
+
+	cb := cfg.Proxies[connectType].CircuitBreaker
+	assert.Equal(t, 5, cb.Failures)
+	assert.Equal(t, 10, cb.MinRequests)
+	assert.Equal(t, time.Second*10, cb.Window)
+	assert.Equal(t, time.Second*30, cb.OpenTimeout)
+	assert.Equal(t, 1, cb.HalfOpenProbes)
+
+	cfg = &Config{Proxies: map[string]*ProxyConfig{connectType: {CircuitBreaker: &CircuitBreaker{FailureRate: 2}}}}
+	require.Error(t, cfg.InitDefaults())
+
+	cfg = &Config{Proxies: map[string]*ProxyConfig{connectType: {CircuitBreaker: &CircuitBreaker{
+		Fallback: &Fallback{Allow: true, Error: &FallbackError{Code: 1}},
+	}}}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigRetry(t *testing.T) {
+	cfg := &Config{Proxies: map[string]*ProxyConfig{refreshType: {Retry: &Retry{}}}}
+	require.NoError(t, cfg.InitDefaults())
+
+	rc := cfg.Proxies[refreshType].Retry
+	assert.Equal(t, 3, rc.MaxAttempts)
+	assert.InDelta(t, 0.1, rc.Budget, 0)
+	assert.Equal(t, 10, rc.BudgetBurst)
+
+	// publish is not idempotent
+	cfg = &Config{Proxies: map[string]*ProxyConfig{publishType: {Retry: &Retry{}}}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigListeners(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeTestCert(t, dir)
+
+	cfg := &Config{Listeners: []*Listener{
+		{Address: "unix:///tmp/centrifuge.sock", Permissions: "0660"},
+		{Address: "tcp://10.0.0.1:30001", TLS: &ListenerTLS{Cert: cert, Key: key}},
+	}}
+	require.NoError(t, cfg.InitDefaults())
+
+	cfg = &Config{Listeners: []*Listener{{}}}
+	require.Error(t, cfg.InitDefaults())
+
+	cfg = &Config{Listeners: []*Listener{{Address: "unix:///tmp/centrifuge.sock", Permissions: "rw"}}}
+	require.Error(t, cfg.InitDefaults())
+
+	cfg = &Config{Listeners: []*Listener{{Address: "tcp://:30001", TLS: &ListenerTLS{Cert: cert, Key: filepath.Join(dir, "absent.key")}}}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigHTTPProxy(t *testing.T) {
+	cfg := &Config{HTTPProxy: &HTTPProxy{Address: "tcp://127.0.0.1:30001"}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, 4, cfg.HTTPProxy.MaxRequestSize)
+
+	cfg = &Config{HTTPProxy: &HTTPProxy{}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigEnabledProxies(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, proxyTypes(), cfg.EnabledProxies)
+
+	cfg = &Config{EnabledProxies: []string{"sub_refresh"}}
+	require.Error(t, cfg.InitDefaults())
+
+	cfg = &Config{Proxies: map[string]*ProxyConfig{publishType: {DefaultResponse: &Fallback{}}}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigHandshake(t *testing.T) {
+	cfg := &Config{Handshake: &Handshake{}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, time.Second*10, cfg.Handshake.Timeout)
+	assert.Equal(t, time.Second*5, cfg.Handshake.Interval)
+}
+
+func TestConfigCacheRecovery(t *testing.T) {
+	cfg := &Config{CacheRecovery: &CacheRecovery{Channels: []string{"news:*"}}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, time.Second*10, cfg.CacheRecovery.Timeout)
+	assert.Equal(t, 10000, cfg.CacheRecovery.MaxEntries)
+	assert.Equal(t, time.Hour, cfg.CacheRecovery.TTL)
+
+	cfg = &Config{CacheRecovery: &CacheRecovery{Channels: []string{"news:["}}}
+	require.Error(t, cfg.InitDefaults())
+
+	// the channels are required
+	require.Error(t, (&Config{CacheRecovery: &CacheRecovery{}}).InitDefaults())
+	require.Error(t, (&Config{CacheRecovery: &CacheRecovery{Channels: []string{"news:*"}, MaxEntries: -1}}).InitDefaults())
+}
+
+func TestConfigOutbox(t *testing.T) {
+	cfg := &Config{Outbox: &Outbox{}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, 10000, cfg.Outbox.Size)
+	assert.Equal(t, time.Second*10, cfg.Outbox.Timeout)
+	assert.Equal(t, time.Millisecond*100, cfg.Outbox.InitialBackoff)
+	assert.Equal(t, time.Second*30, cfg.Outbox.MaxBackoff)
+	assert.Equal(t, 10, cfg.Outbox.MaxAttempts)
+
+	// retries until delivered
+	cfg = &Config{Outbox: &Outbox{MaxAttempts: -1}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, -1, cfg.Outbox.MaxAttempts)
+
+	cfg = &Config{Outbox: &Outbox{MaxAttempts: -2}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigBatching(t *testing.T) {
+	cfg := &Config{Batching: &Batching{}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, time.Millisecond*5, cfg.Batching.Window)
+	assert.Equal(t, 100, cfg.Batching.MaxSize)
+	assert.Equal(t, time.Second*10, cfg.Batching.Timeout)
+}
+
+func TestConfigTransport(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, transportGRPC, cfg.Transport)
+
+	cfg = &Config{Transport: transportHTTP, HTTPAPI: &HTTPAPI{Address: "http://127.0.0.1:8000"}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, 100, cfg.HTTPAPI.MaxIdleConns)
+	assert.Equal(t, time.Second*90, cfg.HTTPAPI.IdleTimeout)
+
+	require.Error(t, (&Config{Transport: transportHTTP}).InitDefaults())
+	require.Error(t, (&Config{Transport: "websocket"}).InitDefaults())
+}
+
+func TestConfigIdempotency(t *testing.T) {
+	cfg := &Config{Idempotency: &Idempotency{}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, time.Minute, cfg.Idempotency.Window)
+	assert.Equal(t, 2, cfg.Idempotency.Retries)
+	assert.Equal(t, time.Millisecond*100, cfg.Idempotency.Backoff)
+}
+
+func TestConfigIdempotencyNegative(t *testing.T) {
+	cfg := &Config{Idempotency: &Idempotency{Retries: -2}}
+	require.Error(t, cfg.InitDefaults())
+
+	cfg = &Config{Idempotency: &Idempotency{Window: -time.Second}}
+	require.Error(t, cfg.InitDefaults())
+}
+
+func TestConfigIdempotencyNoRetries(t *testing.T) {
+	cfg := &Config{Idempotency: &Idempotency{Retries: -1}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, -1, cfg.Idempotency.Retries)
+	assert.Equal(t, 0, newIdempotency(cfg.Idempotency, testLogger()).retries)
+}
+
+func TestConfigAuthACL(t *testing.T) {
+	cfg := &Config{Auth: &Auth{Tokens: []string{"t1"}}, ACL: &ACL{Publish: []string{"chat:*"}}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, "authorization", cfg.Auth.Metadata)
+
+	require.Error(t, (&Config{Auth: &Auth{}}).InitDefaults())
+	require.Error(t, (&Config{ACL: &ACL{}}).InitDefaults())
+	require.Error(t, (&Config{ACL: &ACL{Subscribe: []string{"chat:["}}}).InitDefaults())
+}
+
+func TestConfigTokens(t *testing.T) {
+	cfg := &Config{Tokens: &Tokens{Keys: []*TokenKey{{ID: "k1", Algorithm: "HS256", Secret: "s"}}}}
+	require.NoError(t, cfg.InitDefaults())
+	assert.Equal(t, time.Hour, cfg.Tokens.TTL)
+	assert.Equal(t, "k1", cfg.Tokens.ActiveKey)
+
+	tests := map[string]*Tokens{
+		"no keys":       {},
+		"algorithm":     {Keys: []*TokenKey{{Algorithm: "none", Secret: "s"}}},
+		"no secret":     {Keys: []*TokenKey{{Algorithm: "HS256"}}},
+		"missing file":  {Keys: []*TokenKey{{Algorithm: "RS256", File: "/does/not/exist.pem"}}},
+		"missing kid":   {Keys: []*TokenKey{{Algorithm: "HS256", Secret: "a"}, {ID: "k2", Algorithm: "HS256", Secret: "b"}}},
+		"duplicate kid": {Keys: []*TokenKey{{ID: "k", Algorithm: "HS256", Secret: "a"}, {ID: "k", Algorithm: "HS256", Secret: "b"}}},
+		"active key":    {Keys: []*TokenKey{{ID: "k1", Algorithm: "HS256", Secret: "s"}}, ActiveKey: "k2"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Error(t, tc.InitDefaults())
+		})
+	}
+}
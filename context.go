@@ -0,0 +1,85 @@
+package centrifuge
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// payload context versions
+const (
+	// the incoming gRPC metadata with the proxy type appended under the `type` key
+	contextV1 int = 1
+	// workerContext
+	contextV2 int = 2
+)
+
+// workerContext is the typed payload context sent to the workers with context_version: 2
+type workerContext struct {
+	Version  int                 `json:"version"`
+	Type     string              `json:"type"`
+	Metadata map[string][]string `json:"metadata"`
+	// PeerAddr is the address of the Centrifugo node which sent the request
+	PeerAddr string `json:"peer_addr,omitempty"`
+	// Deadline of the request, if set by Centrifugo
+	Deadline   *time.Time    `json:"deadline,omitempty"`
+	RequestID  string        `json:"request_id"`
+	Trace      *traceContext `json:"trace,omitempty"`
+	ReceivedAt time.Time     `json:"received_at"`
+}
+
+// traceContext is the W3C trace context propagated by Centrifugo in the request metadata
+type traceContext struct {
+	Traceparent string `json:"traceparent"`
+	Tracestate  string `json:"tracestate,omitempty"`
+}
+
+// payloadContext encodes the payload context for the worker.
+func payloadContext(ctx context.Context, version int, req *Request) ([]byte, error) {
+	if version != contextV2 {
+		md := req.Meta.Copy()
+		md.Append("type", req.Type)
+
+		return json.Marshal(md)
+	}
+
+	wc := &workerContext{
+		Version:    contextV2,
+		Type:       req.Type,
+		Metadata:   req.Meta,
+		RequestID:  req.ID,
+		Trace:      traceFromMetadata(req.Meta),
+		ReceivedAt: req.ReceivedAt,
+	}
+
+	if wc.Metadata == nil {
+		wc.Metadata = metadata.MD{}
+	}
+
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		wc.PeerAddr = pr.Addr.String()
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		wc.Deadline = &dl
+	}
+
+	return json.Marshal(wc)
+}
+
+func traceFromMetadata(md metadata.MD) *traceContext {
+	tp := md.Get("traceparent")
+	if len(tp) == 0 {
+		return nil
+	}
+
+	tc := &traceContext{Traceparent: tp[0]}
+	if ts := md.Get("tracestate"); len(ts) > 0 {
+		tc.Tracestate = ts[0]
+	}
+
+	return tc
+}
@@ -0,0 +1,76 @@
+package centrifuge
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestPayloadContextV1(t *testing.T) {
+	req := &Request{ID: "req-1", Type: connectType, Meta: metadata.Pairs("origin", "example.com")}
+
+	data, err := payloadContext(t.Context(), contextV1, req)
+	require.NoError(t, err)
+
+	md := map[string][]string{}
+	require.NoError(t, json.Unmarshal(data, &md))
+
+	assert.Equal(t, map[string][]string{"origin": {"example.com"}, "type": {connectType}}, md)
+	// the request metadata is not modified, so a retried call does not get a second type
+	assert.Empty(t, req.Meta.Get("type"))
+}
+
+func TestPayloadContextV2(t *testing.T) {
+	received := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	deadline := received.Add(time.Minute)
+
+	req := &Request{
+		ID:         "req-2",
+		Type:       subscribeType,
+		Meta:       metadata.Pairs("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "tracestate", "rr=1"),
+		ReceivedAt: received,
+	}
+
+	ctx := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5555}})
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	data, err := payloadContext(ctx, contextV2, req)
+	require.NoError(t, err)
+
+	wc := &workerContext{}
+	require.NoError(t, json.Unmarshal(data, wc))
+
+	assert.Equal(t, contextV2, wc.Version)
+	assert.Equal(t, subscribeType, wc.Type)
+	assert.Equal(t, "req-2", wc.RequestID)
+	assert.Equal(t, "10.0.0.1:5555", wc.PeerAddr)
+	require.NotNil(t, wc.Deadline)
+	assert.True(t, deadline.Equal(*wc.Deadline))
+	assert.True(t, received.Equal(wc.ReceivedAt))
+	require.NotNil(t, wc.Trace)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wc.Trace.Traceparent)
+	assert.Equal(t, "rr=1", wc.Trace.Tracestate)
+	// the type is a field of its own, not a metadata key
+	assert.NotContains(t, wc.Metadata, "type")
+}
+
+func TestPayloadContextV2Minimal(t *testing.T) {
+	data, err := payloadContext(t.Context(), contextV2, &Request{ID: "req-3", Type: rpcType})
+	require.NoError(t, err)
+
+	raw := map[string]any{}
+	require.NoError(t, json.Unmarshal(data, &raw))
+
+	assert.Equal(t, map[string]any{}, raw["metadata"])
+	assert.NotContains(t, raw, "peer_addr")
+	assert.NotContains(t, raw, "deadline")
+	assert.NotContains(t, raw, "trace")
+}
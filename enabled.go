@@ -0,0 +1,82 @@
+package centrifuge
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// enabledProxies answers the calls of the disabled proxy types without touching a worker:
+// with the configured default response or with the gRPC Unimplemented code. The calls
+// the workers declared as unsupported in the handshake are answered the same way.
+type enabledProxies struct {
+	enabled  []string
+	defaults map[string]*Fallback
+	metrics  *proxyMetrics
+}
+
+func newEnabledProxies(enabled []string, proxies map[string]*ProxyConfig, metrics *proxyMetrics) *enabledProxies {
+	e := &enabledProxies{
+		enabled:  enabled,
+		defaults: make(map[string]*Fallback),
+		metrics:  metrics,
+	}
+
+	for typ, pc := range proxies {
+		if pc.DefaultResponse != nil {
+			e.defaults[typ] = pc.DefaultResponse
+		}
+	}
+
+	if metrics != nil {
+		for _, typ := range proxyTypes() {
+			v := 0.0
+			if slices.Contains(enabled, typ) {
+				v = 1
+			}
+
+			metrics.enabled.WithLabelValues(typ).Set(v)
+		}
+	}
+
+	return e
+}
+
+func (e *enabledProxies) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		if slices.Contains(e.enabled, req.Type) {
+			return next(ctx, req)
+		}
+
+		return e.reject(req)
+	}
+}
+
+// reject answers a call not served by the workers.
+func (e *enabledProxies) reject(req *Request) (proto.Message, error) {
+	if e.metrics != nil {
+		e.metrics.disabled.WithLabelValues(req.Type).Inc()
+	}
+
+	if fb, ok := e.defaults[req.Type]; ok {
+		return fallback(req, fb)
+	}
+
+	return nil, status.Errorf(codes.Unimplemented, "%s proxy is disabled", req.Type)
+}
+
+// logEnabledProxies reports the enabled and disabled proxy types at startup.
+func logEnabledProxies(log *slog.Logger, enabled []string) {
+	var disabled []string
+	for _, typ := range proxyTypes() {
+		if !slices.Contains(enabled, typ) {
+			disabled = append(disabled, typ)
+		}
+	}
+
+	log.Info("proxy types", "enabled", enabled, "disabled", disabled)
+}
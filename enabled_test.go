@@ -0,0 +1,44 @@
+package centrifuge
+
+import (
+	"context"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEnabledProxies(t *testing.T) {
+	metrics := newProxyMetrics()
+	e := newEnabledProxies([]string{connectType, subscribeType}, map[string]*ProxyConfig{
+		rpcType: {DefaultResponse: &Fallback{Error: &FallbackError{Code: 404, Message: "not found"}}},
+	}, metrics)
+
+	var calls int
+	h := e.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		calls++
+		return req.NewResponse(), nil
+	})
+
+	_, err := h(t.Context(), connectRequest())
+	require.NoError(t, err)
+
+	// disabled, no default response
+	_, err = h(t.Context(), &Request{Type: publishType, Message: &centrifugov1.PublishRequest{}, response: &centrifugov1.PublishResponse{}})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	// disabled, default response
+	resp, err := h(t.Context(), &Request{Type: rpcType, Message: &centrifugov1.RPCRequest{}, response: &centrifugov1.RPCResponse{}})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(404), resp.(*centrifugov1.RPCResponse).GetError().GetCode())
+
+	assert.Equal(t, 1, calls)
+	assert.InDelta(t, 1, counterValue(t, metrics.disabled.WithLabelValues(publishType)), 0)
+	assert.InDelta(t, 1, counterValue(t, metrics.disabled.WithLabelValues(rpcType)), 0)
+	assert.Equal(t, len(proxyTypes()), collectCount(t, metrics.enabled))
+}
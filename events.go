@@ -0,0 +1,149 @@
+package centrifuge
+
+import (
+	"context"
+	"encoding/json"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/roadrunner-server/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// eventType is the RoadRunner event bus type of the plugin events. The bus matches
+// the events by `<plugin>.<type>`, e.g. `centrifuge.connect.allowed` or `centrifuge.channel.*`.
+type eventType string
+
+func (e eventType) String() string {
+	return string(e)
+}
+
+// event type suffixes and the non-proxy event types
+const (
+	// <proxy type>.allowed, the worker allowed the call, or the plugin did with the `fallback` attribute set
+	eventAllowed string = "allowed"
+	// <proxy type>.denied, the worker answered with a Centrifugo error or a disconnect, or the plugin
+	// did with the `fallback` attribute set
+	eventDenied string = "denied"
+
+	eventChannelOccupied eventType = "channel.occupied"
+	eventChannelVacated  eventType = "channel.vacated"
+	// the call did not produce a response
+	eventWorkerError eventType = "worker.error"
+)
+
+// Centrifugo channel state event types
+const (
+	channelOccupied string = "occupied"
+	channelVacated  string = "vacated"
+)
+
+// ProxyEvent is the JSON message of the events published by the plugin on the RoadRunner event bus.
+type ProxyEvent struct {
+	// RequestID is the proxy call ID, the same as in the access log and the payload context.
+	RequestID string `json:"request_id"`
+	// Type is the proxy type, e.g. "connect" or "notifychannelstate".
+	Type      string `json:"type"`
+	Client    string `json:"client,omitempty"`
+	User      string `json:"user,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	RPCMethod string `json:"rpc_method,omitempty"`
+	// TimeMs is the time of a channel state change reported by Centrifugo.
+	TimeMs         int64  `json:"time_ms,omitempty"`
+	ErrorCode      uint32 `json:"error_code,omitempty"`
+	DisconnectCode uint32 `json:"disconnect_code,omitempty"`
+	Error          string `json:"error,omitempty"`
+	// Fallback is set when the plugin answered without a worker, e.g. the default response of a
+	// disabled proxy type or the fallback of an open circuit breaker.
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// eventEmitter publishes the proxy call outcomes on the RoadRunner event bus, so other plugins
+// can react to them without a worker. Nothing is built while the bus has no subscribers.
+type eventEmitter struct {
+	bus events.EventBus
+}
+
+func newEventEmitter(bus events.EventBus) *eventEmitter {
+	return &eventEmitter{bus: bus}
+}
+
+func (e *eventEmitter) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		resp, err := next(ctx, req)
+
+		if e.bus.Len() > 0 {
+			e.emit(req, resp, err)
+		}
+
+		return resp, err
+	}
+}
+
+func (e *eventEmitter) emit(req *Request, resp proto.Message, err error) {
+	// Centrifugo reports the channel state regardless of the worker answer
+	if m, ok := req.Message.(*centrifugov1.NotifyChannelStateRequest); ok {
+		for _, ce := range m.GetEvents() {
+			var typ eventType
+			switch ce.GetType() {
+			case channelOccupied:
+				typ = eventChannelOccupied
+			case channelVacated:
+				typ = eventChannelVacated
+			default:
+				continue
+			}
+
+			e.send(typ, &ProxyEvent{RequestID: req.ID, Type: req.Type, Channel: ce.GetChannel(), TimeMs: ce.GetTimeMs()})
+		}
+	}
+
+	ev := newProxyEvent(req)
+
+	switch outcome(resp, err) {
+	case resultAllowed:
+		e.send(eventType(req.Type+"."+eventAllowed), ev)
+	case resultError:
+		ev.ErrorCode = resp.(errorResponse).GetError().GetCode()
+		e.send(eventType(req.Type+"."+eventDenied), ev)
+	case resultDisconnect:
+		ev.DisconnectCode = resp.(disconnectResponse).GetDisconnect().GetCode()
+		e.send(eventType(req.Type+"."+eventDenied), ev)
+	case resultFailed:
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		e.send(eventWorkerError, ev)
+	}
+}
+
+func (e *eventEmitter) send(typ eventType, ev *ProxyEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	e.bus.Send(events.NewEvent(typ, name, string(data)))
+}
+
+func newProxyEvent(req *Request) *ProxyEvent {
+	ev := &ProxyEvent{
+		RequestID: req.ID,
+		Type:      req.Type,
+		Fallback:  req.fallback,
+	}
+
+	if m, ok := req.Message.(interface{ GetClient() string }); ok {
+		ev.Client = m.GetClient()
+	}
+	if m, ok := req.Message.(interface{ GetUser() string }); ok {
+		ev.User = m.GetUser()
+	}
+	if m, ok := req.Message.(interface{ GetChannel() string }); ok {
+		ev.Channel = m.GetChannel()
+	}
+	if m, ok := req.Message.(interface{ GetMethod() string }); ok {
+		ev.RPCMethod = m.GetMethod()
+	}
+
+	return ev
+}
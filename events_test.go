@@ -0,0 +1,182 @@
+package centrifuge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/roadrunner-server/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func subscribeEvents(t *testing.T, pattern string) chan events.Event {
+	t.Helper()
+
+	bus, id := events.NewEventBus()
+	ch := make(chan events.Event, 10)
+	require.NoError(t, bus.SubscribeP(id, pattern, ch))
+	t.Cleanup(func() { bus.Unsubscribe(id) })
+
+	return ch
+}
+
+func receiveEvent(t *testing.T, ch chan events.Event) (string, *ProxyEvent) {
+	t.Helper()
+
+	select {
+	case ev := <-ch:
+		pe := &ProxyEvent{}
+		require.NoError(t, json.Unmarshal([]byte(ev.Message()), pe))
+		assert.Equal(t, name, ev.Plugin())
+
+		return ev.Type().String(), pe
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return "", nil
+	}
+}
+
+func TestEventsConnect(t *testing.T) {
+	ch := subscribeEvents(t, "centrifuge.connect.*")
+	bus, _ := events.NewEventBus()
+
+	resp := &centrifugov1.ConnectResponse{}
+	h := newEventEmitter(bus).Middleware(func(context.Context, *Request) (proto.Message, error) {
+		return resp, nil
+	})
+
+	req := connectRequest()
+	req.ID = "req-1"
+	req.Message = &centrifugov1.ConnectRequest{Client: "client-1"}
+
+	_, err := h(t.Context(), req)
+	require.NoError(t, err)
+
+	typ, ev := receiveEvent(t, ch)
+	assert.Equal(t, "connect.allowed", typ)
+	assert.Equal(t, "req-1", ev.RequestID)
+	assert.Equal(t, connectType, ev.Type)
+	assert.Equal(t, "client-1", ev.Client)
+
+	resp.Disconnect = &centrifugov1.Disconnect{Code: 4501, Reason: "unauthorized"}
+	_, err = h(t.Context(), req)
+	require.NoError(t, err)
+
+	typ, ev = receiveEvent(t, ch)
+	assert.Equal(t, "connect.denied", typ)
+	assert.Equal(t, uint32(4501), ev.DisconnectCode)
+}
+
+func TestEventsSubscribeDenied(t *testing.T) {
+	ch := subscribeEvents(t, "centrifuge.subscribe.*")
+	bus, _ := events.NewEventBus()
+
+	h := newEventEmitter(bus).Middleware(func(context.Context, *Request) (proto.Message, error) {
+		return &centrifugov1.SubscribeResponse{Error: &centrifugov1.Error{Code: 403, Message: "permission denied"}}, nil
+	})
+
+	_, err := h(t.Context(), &Request{
+		Type:     subscribeType,
+		Message:  &centrifugov1.SubscribeRequest{User: "42", Channel: "news"},
+		response: &centrifugov1.SubscribeResponse{},
+	})
+	require.NoError(t, err)
+
+	typ, ev := receiveEvent(t, ch)
+	assert.Equal(t, "subscribe.denied", typ)
+	assert.Equal(t, "42", ev.User)
+	assert.Equal(t, "news", ev.Channel)
+	assert.Equal(t, uint32(403), ev.ErrorCode)
+}
+
+func TestEventsChannelState(t *testing.T) {
+	ch := subscribeEvents(t, "centrifuge.channel.*")
+	bus, _ := events.NewEventBus()
+
+	// the channel state is published even if the worker failed
+	h := newEventEmitter(bus).Middleware(func(context.Context, *Request) (proto.Message, error) {
+		return nil, errors.New("worker failed")
+	})
+
+	_, err := h(t.Context(), &Request{
+		Type: notifyChannelStateType,
+		Message: &centrifugov1.NotifyChannelStateRequest{Events: []*centrifugov1.ChannelEvent{
+			{Channel: "a", Type: channelOccupied, TimeMs: 1},
+			{Channel: "b", Type: channelVacated, TimeMs: 2},
+			{Channel: "c", Type: "unknown"},
+		}},
+		response: &centrifugov1.NotifyChannelStateResponse{},
+	})
+	require.Error(t, err)
+
+	typ, ev := receiveEvent(t, ch)
+	assert.Equal(t, "channel.occupied", typ)
+	assert.Equal(t, "a", ev.Channel)
+	assert.Equal(t, int64(1), ev.TimeMs)
+
+	typ, ev = receiveEvent(t, ch)
+	assert.Equal(t, "channel.vacated", typ)
+	assert.Equal(t, "b", ev.Channel)
+
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected event %s", ev.Type())
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestEventsWorkerError(t *testing.T) {
+	ch := subscribeEvents(t, "centrifuge.worker.error")
+	bus, _ := events.NewEventBus()
+
+	h := newEventEmitter(bus).Middleware(func(context.Context, *Request) (proto.Message, error) {
+		return nil, errors.New("worker empty response")
+	})
+
+	_, err := h(t.Context(), &Request{
+		Type:     rpcType,
+		Message:  &centrifugov1.RPCRequest{Method: "getUser"},
+		response: &centrifugov1.RPCResponse{},
+	})
+	require.Error(t, err)
+
+	typ, ev := receiveEvent(t, ch)
+	assert.Equal(t, "worker.error", typ)
+	assert.Equal(t, rpcType, ev.Type)
+	assert.Equal(t, "getUser", ev.RPCMethod)
+	assert.Equal(t, "worker empty response", ev.Error)
+}
+
+func TestEventsFallback(t *testing.T) {
+	ch := subscribeEvents(t, "centrifuge.connect.*")
+	bus, _ := events.NewEventBus()
+
+	bs, _ := newTestBreakers(t, &CircuitBreaker{Failures: 1, OpenTimeout: time.Minute, Fallback: &Fallback{Allow: true}})
+	w := &flakyWorker{}
+	h := newEventEmitter(bus).Middleware(bs.Middleware(w.handle))
+
+	// the worker allowed the call
+	_, err := h(t.Context(), connectRequest())
+	require.NoError(t, err)
+
+	typ, ev := receiveEvent(t, ch)
+	assert.Equal(t, "connect.allowed", typ)
+	assert.False(t, ev.Fallback)
+
+	w.failing = true
+	_, _ = h(t.Context(), connectRequest())
+
+	// the open circuit allowed the call without a worker
+	_, err = h(t.Context(), connectRequest())
+	require.NoError(t, err)
+
+	typ, ev = receiveEvent(t, ch)
+	assert.Equal(t, "connect.allowed", typ)
+	assert.True(t, ev.Fallback)
+	assert.Equal(t, 2, w.calls)
+}
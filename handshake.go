@@ -0,0 +1,164 @@
+package centrifuge
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/goridge/v4/pkg/frame"
+	"github.com/roadrunner-server/pool/v2/payload"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// handshakeType is the payload context type of the capability handshake
+const handshakeType string = "handshake"
+
+// protocolVersion is the worker protocol version spoken by the plugin
+const protocolVersion int = 1
+
+// capabilities are declared by the worker in the handshake response
+type capabilities struct {
+	ProtocolVersion int `json:"protocol_version"`
+	// Proxies are the proxy types handled by the worker
+	Proxies []string `json:"proxies"`
+	// RPCMethods handled by the worker, any method if empty
+	RPCMethods []string `json:"rpc_methods"`
+}
+
+// handshakeRequest is the handshake payload body
+type handshakeRequest struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Proxies         []string `json:"proxies"`
+}
+
+// supports reports whether the worker handles the request.
+func (c *capabilities) supports(req *Request) bool {
+	if !slices.Contains(c.Proxies, req.Type) {
+		return false
+	}
+
+	if req.Type != rpcType || len(c.RPCMethods) == 0 {
+		return true
+	}
+
+	m, ok := req.Message.(interface{ GetMethod() string })
+
+	return ok && slices.Contains(c.RPCMethods, m.GetMethod())
+}
+
+// workerCapabilities gates the calls routed to one worker pool by the capabilities its workers
+// declared in the handshake. The pool hands a call to any of its workers, so the capabilities of
+// one worker are taken for the whole pool and asked again whenever the worker set changes.
+type workerCapabilities struct {
+	enabled *enabledProxies
+	// nil until a successful handshake, every call is routed to the workers then
+	caps atomic.Pointer[capabilities]
+
+	mu sync.Mutex
+	// PIDs of the pool workers at the last handshake
+	pids []int64
+	// the last handshake failed, e.g. an older worker does not know the handshake request and exits,
+	// the restarted workers are not asked again until a Reset
+	legacy bool
+}
+
+func newWorkerCapabilities(enabled *enabledProxies) *workerCapabilities {
+	return &workerCapabilities{enabled: enabled}
+}
+
+func (w *workerCapabilities) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		if caps := w.caps.Load(); caps != nil && !caps.supports(req) {
+			return w.enabled.reject(req)
+		}
+
+		return next(ctx, req)
+	}
+}
+
+// setPIDs records the PIDs of the pool workers and reports whether the workers should be asked
+// again: the PIDs changed and the pool is not legacy.
+func (w *workerCapabilities) setPIDs(pids []int64) bool {
+	slices.Sort(pids)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if slices.Equal(w.pids, pids) {
+		return false
+	}
+
+	w.pids = pids
+
+	return !w.legacy
+}
+
+// setResult records the result of a handshake, nil caps for a failed one.
+func (w *workerCapabilities) setResult(caps *capabilities) {
+	w.mu.Lock()
+	w.legacy = caps == nil
+	w.mu.Unlock()
+
+	w.caps.Store(caps)
+}
+
+// isLegacy reports whether the last handshake failed.
+func (w *workerCapabilities) isLegacy() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.legacy
+}
+
+// takeOver copies the handshake result of another pool, e.g. of the promoted canary pool.
+func (w *workerCapabilities) takeOver(other *workerCapabilities) {
+	other.mu.Lock()
+	legacy := other.legacy
+	other.mu.Unlock()
+
+	w.mu.Lock()
+	w.legacy = legacy
+	w.mu.Unlock()
+
+	w.caps.Store(other.caps.Load())
+}
+
+// execFunc sends a payload to the worker pool
+type execFunc func(ctx context.Context, pld *payload.Payload) (*payload.Payload, error)
+
+// handshake asks a worker for its capabilities. The payload context carries the `handshake` type,
+// the body is a JSON handshakeRequest, the worker answers with JSON capabilities.
+func handshake(ctx context.Context, exec execFunc, contextVersion int) (*capabilities, error) {
+	const op = errors.Op("centrifuge_handshake")
+
+	body, err := json.Marshal(&handshakeRequest{ProtocolVersion: protocolVersion, Proxies: proxyTypes()})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	meta, err := payloadContext(ctx, contextVersion, &Request{Type: handshakeType, Meta: metadata.MD{}})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	resp, err := exec(ctx, &payload.Payload{
+		Context: meta,
+		Body:    body,
+		Codec:   frame.CodecJSON,
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	caps := &capabilities{}
+	err = json.Unmarshal(resp.Body, caps)
+	if err != nil {
+		return nil, errors.E(op, errors.Errorf("invalid handshake response: %v", err))
+	}
+
+	return caps, nil
+}
@@ -0,0 +1,146 @@
+package centrifuge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/roadrunner-server/pool/v2/payload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHandshake(t *testing.T) {
+	exec := func(_ context.Context, pld *payload.Payload) (*payload.Payload, error) {
+		var wc workerContext
+		require.NoError(t, json.Unmarshal(pld.Context, &wc))
+		assert.Equal(t, handshakeType, wc.Type)
+
+		var hr handshakeRequest
+		require.NoError(t, json.Unmarshal(pld.Body, &hr))
+		assert.Equal(t, protocolVersion, hr.ProtocolVersion)
+		assert.Equal(t, proxyTypes(), hr.Proxies)
+
+		return &payload.Payload{Body: []byte(`{"protocol_version":1,"proxies":["connect","rpc"],"rpc_methods":["getUser"]}`)}, nil
+	}
+
+	caps, err := handshake(t.Context(), exec, contextV2)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, caps.ProtocolVersion)
+	assert.Equal(t, []string{connectType, rpcType}, caps.Proxies)
+	assert.Equal(t, []string{"getUser"}, caps.RPCMethods)
+}
+
+func TestHandshakeFailed(t *testing.T) {
+	_, err := handshake(t.Context(), func(context.Context, *payload.Payload) (*payload.Payload, error) {
+		return nil, errors.New("worker empty response")
+	}, contextV1)
+	require.Error(t, err)
+
+	_, err = handshake(t.Context(), func(context.Context, *payload.Payload) (*payload.Payload, error) {
+		return &payload.Payload{Body: []byte("not json")}, nil
+	}, contextV1)
+	require.Error(t, err)
+}
+
+func TestCapabilitiesRouting(t *testing.T) {
+	wc := newWorkerCapabilities(newEnabledProxies(proxyTypes(), nil, nil))
+	wc.caps.Store(&capabilities{ProtocolVersion: 1, Proxies: []string{connectType, rpcType}, RPCMethods: []string{"getUser"}})
+
+	var calls int
+	h := wc.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		calls++
+		return req.NewResponse(), nil
+	})
+
+	rpcRequest := func(method string) *Request {
+		return &Request{Type: rpcType, Message: &centrifugov1.RPCRequest{Method: method}, response: &centrifugov1.RPCResponse{}}
+	}
+
+	_, err := h(t.Context(), connectRequest())
+	require.NoError(t, err)
+	_, err = h(t.Context(), rpcRequest("getUser"))
+	require.NoError(t, err)
+
+	_, err = h(t.Context(), rpcRequest("deleteUser"))
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = h(t.Context(), &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{}, response: &centrifugov1.SubscribeResponse{}})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	assert.Equal(t, 2, calls)
+
+	// the failed handshake routes everything to the workers
+	wc.caps.Store(nil)
+	_, err = h(t.Context(), rpcRequest("deleteUser"))
+	require.NoError(t, err)
+}
+
+func TestWorkerCapabilitiesPIDs(t *testing.T) {
+	wc := newWorkerCapabilities(nil)
+
+	assert.True(t, wc.setPIDs([]int64{2, 1}))
+	assert.False(t, wc.setPIDs([]int64{1, 2}))
+	// a worker was restarted
+	assert.True(t, wc.setPIDs([]int64{1, 3}))
+
+	// the workers of a legacy pool are not asked again
+	wc.setResult(nil)
+	assert.False(t, wc.setPIDs([]int64{1, 4}))
+
+	wc.setResult(&capabilities{Proxies: []string{connectType}})
+	assert.True(t, wc.setPIDs([]int64{1, 5}))
+}
+
+func TestPluginHandshakeFailed(t *testing.T) {
+	p := &Plugin{
+		log: testLogger(),
+		cfg: &Config{Handshake: &Handshake{Timeout: time.Second}, ContextVersion: contextV1},
+	}
+	wc := newWorkerCapabilities(newEnabledProxies(proxyTypes(), nil, nil))
+	wc.caps.Store(&capabilities{Proxies: []string{connectType}})
+
+	p.handshake(stablePool, &fakePool{execErr: errors.New("exec failed")}, wc)
+	assert.Nil(t, wc.caps.Load())
+	assert.True(t, wc.isLegacy())
+}
+
+func TestPluginHandshakes(t *testing.T) {
+	stable, cp := &fakePool{execErr: errors.New("exec failed")}, &fakePool{execErr: errors.New("exec failed")}
+	enabled := newEnabledProxies(proxyTypes(), nil, nil)
+
+	p := &Plugin{
+		log:        testLogger(),
+		cfg:        &Config{Handshake: &Handshake{Timeout: time.Second}, ContextVersion: contextV1},
+		pool:       stable,
+		canaryPool: cp,
+		stableCaps: newWorkerCapabilities(enabled),
+		canaryCaps: newWorkerCapabilities(enabled),
+	}
+
+	// each pool is asked on its own
+	p.handshakes()
+	assert.Equal(t, int32(1), stable.execs.Load())
+	assert.Equal(t, int32(1), cp.execs.Load())
+
+	// the promoted pool takes over the canary handshake result, the legacy pool is not asked again
+	p.proxy = newProxy(testLogger(), newPoolMuWrapper(stable, &p.mu), newRedactor(nil))
+	p.canary = newCanary(&Canary{Types: proxyTypes()}, poolHandler(canaryPool), nil, testLogger())
+
+	_, err := p.promoteCanary(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), stable.execs.Load())
+	assert.Equal(t, int32(1), cp.execs.Load())
+	assert.True(t, p.stableCaps.isLegacy())
+
+	// asked again on reset
+	require.NoError(t, p.Reset())
+	assert.Equal(t, int32(2), cp.execs.Load())
+}
@@ -0,0 +1,105 @@
+package centrifuge
+
+import (
+	"net/http"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthInterval is the period of the proxy gRPC health status updates
+const healthInterval = time.Second
+
+// healthServices are the health checked services: the overall server health and the proxy service
+func healthServices() []string {
+	return []string{"", centrifugov1.CentrifugoProxy_ServiceDesc.ServiceName}
+}
+
+func newHealthServer() *health.Server {
+	hs := health.NewServer()
+	// not serving until the pool is started
+	for _, svc := range healthServices() {
+		hs.SetServingStatus(svc, healthv1.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return hs
+}
+
+// watchHealth keeps the gRPC health status in sync with the workers until stop is closed.
+func (p *Plugin) watchHealth(stop chan struct{}) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	p.updateHealth()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.updateHealth()
+		}
+	}
+}
+
+// updateHealth sets the gRPC health status from the same worker pool state as Ready.
+// The status stays NOT_SERVING while the pool is being reset.
+func (p *Plugin) updateHealth() {
+	if p.health == nil {
+		return
+	}
+
+	p.healthMu.Lock()
+	resets := p.resets
+	p.healthMu.Unlock()
+
+	st := healthv1.HealthCheckResponse_NOT_SERVING
+	if rd, err := p.Ready(); err == nil && rd.Code == http.StatusOK {
+		st = healthv1.HealthCheckResponse_SERVING
+	}
+
+	p.applyHealth(resets, st)
+}
+
+// applyHealth sets the status read after `resets` resets were started, unless the pool is being
+// reset or a reset was started since the status was read.
+func (p *Plugin) applyHealth(resets uint64, st healthv1.HealthCheckResponse_ServingStatus) {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+
+	if p.resetting || p.resets != resets {
+		return
+	}
+
+	p.setHealth(st)
+}
+
+// startReset sets NOT_SERVING until finishReset.
+func (p *Plugin) startReset() {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+
+	p.resetting = true
+	p.resets++
+	p.setHealth(healthv1.HealthCheckResponse_NOT_SERVING)
+}
+
+func (p *Plugin) finishReset() {
+	p.healthMu.Lock()
+	p.resetting = false
+	p.healthMu.Unlock()
+
+	p.updateHealth()
+}
+
+func (p *Plugin) setHealth(st healthv1.HealthCheckResponse_ServingStatus) {
+	if p.health == nil {
+		return
+	}
+
+	for _, svc := range healthServices() {
+		p.health.SetServingStatus(svc, st)
+	}
+}
@@ -0,0 +1,75 @@
+package centrifuge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func healthStatus(t *testing.T, p *Plugin, svc string) healthv1.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := p.health.Check(t.Context(), &healthv1.HealthCheckRequest{Service: svc})
+	require.NoError(t, err)
+
+	return resp.GetStatus()
+}
+
+func TestHealthNotServingWithoutWorkers(t *testing.T) {
+	p := &Plugin{health: newHealthServer()}
+
+	for _, svc := range healthServices() {
+		assert.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, healthStatus(t, p, svc))
+	}
+
+	p.setHealth(healthv1.HealthCheckResponse_SERVING)
+	// no pool, same as Ready
+	p.updateHealth()
+
+	for _, svc := range healthServices() {
+		assert.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, healthStatus(t, p, svc))
+	}
+}
+
+func TestHealthNotServingDuringReset(t *testing.T) {
+	var during healthv1.HealthCheckResponse_ServingStatus
+
+	p := &Plugin{log: testLogger(), health: newHealthServer()}
+	p.pool = &fakePool{onReset: func() {
+		// the updates are ignored while resetting
+		p.updateHealth()
+		during = healthStatus(t, p, "")
+	}}
+	p.setHealth(healthv1.HealthCheckResponse_SERVING)
+
+	require.NoError(t, p.Reset())
+	assert.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, during)
+	assert.False(t, p.resetting)
+}
+
+func TestHealthStaleUpdate(t *testing.T) {
+	p := &Plugin{log: testLogger(), health: newHealthServer(), pool: &fakePool{}}
+
+	// the pool state was read before the reset
+	resets := p.resets
+	p.startReset()
+	p.applyHealth(resets, healthv1.HealthCheckResponse_SERVING)
+	assert.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, healthStatus(t, p, ""))
+
+	p.finishReset()
+	// applied after the reset finished
+	p.applyHealth(resets, healthv1.HealthCheckResponse_SERVING)
+	assert.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, healthStatus(t, p, ""))
+
+	p.applyHealth(p.resets, healthv1.HealthCheckResponse_SERVING)
+	assert.Equal(t, healthv1.HealthCheckResponse_SERVING, healthStatus(t, p, ""))
+}
+
+func TestHealthUnknownService(t *testing.T) {
+	p := &Plugin{health: newHealthServer()}
+
+	_, err := p.health.Check(t.Context(), &healthv1.HealthCheckRequest{Service: "unknown"})
+	require.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package centrifuge
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// API transports
+const (
+	transportGRPC string = "grpc"
+	transportHTTP string = "http"
+)
+
+// the size of the error body included in the error message
+const maxErrorBody = 512
+
+// httpConn is a grpc.ClientConnInterface speaking the Centrifugo HTTP API, so the generated
+// API client serves the same method set over HTTP. The gRPC method /centrifugal.centrifugo.api.CentrifugoApi/PresenceStats
+// is sent as POST /api/presence_stats. The errors are gRPC status errors, as returned by the gRPC transport.
+type httpConn struct {
+	address string
+	key     string
+	client  *http.Client
+}
+
+func newHTTPConn(cfg *HTTPAPI, tlsCfg *tls.Config) *httpConn {
+	// keep-alive connections to a single host
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsCfg
+	t.MaxIdleConns = cfg.MaxIdleConns
+	t.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	t.IdleConnTimeout = cfg.IdleTimeout
+
+	return &httpConn{
+		address: strings.TrimSuffix(cfg.Address, "/"),
+		key:     cfg.Key,
+		client:  &http.Client{Transport: t},
+	}
+}
+
+func (h *httpConn) Invoke(ctx context.Context, method string, args, reply any, _ ...grpc.CallOption) error {
+	in, ok := args.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected request type %T", args)
+	}
+
+	out, ok := reply.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected response type %T", reply)
+	}
+
+	body, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(in)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode the request: %v", err)
+	}
+
+	// the binary data and info are sent in b64data and b64info, other binary fields are rejected
+	body, err = protoJSONToRaw(in.ProtoReflect().Descriptor(), body)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to encode the request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.address+"/api/"+apiMethod(method), bytes.NewReader(body))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if h.key != "" {
+		req.Header.Set("X-API-Key", h.key)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	defer func() {
+		// the connection is reused only if the body was read to the end
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data[:min(len(data), maxErrorBody)]))
+
+		return status.Errorf(httpCode(resp.StatusCode), "centrifugo http api: %s: %s", resp.Status, msg)
+	}
+
+	data, err = rawToProtoJSON(out.ProtoReflect().Descriptor(), data)
+	if err == nil {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, out)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to decode the response: %v", err)
+	}
+
+	return nil
+}
+
+func (h *httpConn) NewStream(context.Context, *grpc.StreamDesc, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "streams are not supported by the centrifugo http api")
+}
+
+// apiMethod converts the gRPC method name to the HTTP API one, e.g. PresenceStats to presence_stats.
+func apiMethod(method string) string {
+	name := []rune(method[strings.LastIndexByte(method, '/')+1:])
+
+	var sb strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(name[i-1]) || (i+1 < len(name) && unicode.IsLower(name[i+1]))) {
+			sb.WriteByte('_')
+		}
+
+		sb.WriteRune(unicode.ToLower(r))
+	}
+
+	return sb.String()
+}
+
+// httpCode maps the HTTP status of a failed call to the closest gRPC code.
+func httpCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
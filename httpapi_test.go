@@ -0,0 +1,174 @@
+package centrifuge
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testHTTPAPI(t *testing.T, h http.HandlerFunc) v1Client.CentrifugoApiClient {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return v1Client.NewCentrifugoApiClient(newHTTPConn(&HTTPAPI{Address: srv.URL + "/", Key: "secret", MaxIdleConns: 2, IdleTimeout: time.Second}, nil))
+}
+
+func TestHTTPAPIPublish(t *testing.T) {
+	api := testHTTPAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/publish", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-API-Key"))
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		// the data is sent as a JSON value, not base64
+		assert.JSONEq(t, `{"channel":"news","data":{"text":"hi"},"skip_history":true}`, string(body))
+
+		_, _ = w.Write([]byte(`{"result":{"offset":5,"epoch":"abc"}}`))
+	})
+
+	resp, err := api.Publish(t.Context(), &v1Client.PublishRequest{Channel: "news", Data: []byte(`{"text":"hi"}`), SkipHistory: true})
+	require.NoError(t, err)
+	assert.Nil(t, resp.GetError())
+	assert.Equal(t, uint64(5), resp.GetResult().GetOffset())
+	assert.Equal(t, "abc", resp.GetResult().GetEpoch())
+}
+
+func TestHTTPAPIBinary(t *testing.T) {
+	api := testHTTPAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		switch r.URL.Path {
+		case "/api/publish":
+			// not a JSON document, Centrifugo decodes b64data
+			assert.JSONEq(t, `{"channel":"news","b64data":"AAE="}`, string(body))
+		case "/api/broadcast":
+			assert.JSONEq(t, `{"channels":["a","b"],"b64data":"AAE="}`, string(body))
+		case "/api/batch":
+			assert.JSONEq(t, `{"commands":[{"publish":{"channel":"a","b64data":"AAE="}}]}`, string(body))
+		}
+
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	_, err := api.Publish(t.Context(), &v1Client.PublishRequest{Channel: "news", Data: []byte{0, 1}})
+	require.NoError(t, err)
+	_, err = api.Broadcast(t.Context(), &v1Client.BroadcastRequest{Channels: []string{"a", "b"}, Data: []byte{0, 1}})
+	require.NoError(t, err)
+	_, err = api.Batch(t.Context(), &v1Client.BatchRequest{Commands: []*v1Client.Command{
+		{Publish: &v1Client.PublishRequest{Channel: "a", Data: []byte{0, 1}}},
+	}})
+	require.NoError(t, err)
+
+	// params has no binary counterpart
+	_, err = api.RPC(t.Context(), &v1Client.RPCRequest{Method: "m", Params: []byte{0, 1}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHTTPAPIErrors(t *testing.T) {
+	api := testHTTPAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/publish":
+			_, _ = w.Write([]byte(`{"error":{"code":102,"message":"unknown channel"}}`))
+		case "/api/broadcast":
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case "/api/presence_stats":
+			time.Sleep(time.Millisecond * 200)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	// a Centrifugo error is a response, as with the gRPC transport
+	resp, err := api.Publish(t.Context(), &v1Client.PublishRequest{Channel: "news"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(102), resp.GetError().GetCode())
+
+	_, err = api.Broadcast(t.Context(), &v1Client.BroadcastRequest{Channels: []string{"news"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Contains(t, err.Error(), "401 Unauthorized")
+
+	_, err = api.Info(t.Context(), &v1Client.InfoRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond*50)
+	defer cancel()
+
+	_, err = api.PresenceStats(ctx, &v1Client.PresenceStatsRequest{Channel: "news"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestHTTPAPIUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := srv.URL
+	srv.Close()
+
+	api := v1Client.NewCentrifugoApiClient(newHTTPConn(&HTTPAPI{Address: addr, MaxIdleConns: 1, IdleTimeout: time.Second}, nil))
+
+	_, err := api.Publish(t.Context(), &v1Client.PublishRequest{Channel: "news"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestHTTPAPIBatch(t *testing.T) {
+	api := testHTTPAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/batch", r.URL.Path)
+
+		var req struct {
+			Commands []map[string]json.RawMessage `json:"commands"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Len(t, req.Commands, 2)
+		assert.JSONEq(t, `{"channel":"a","data":[1,2]}`, string(req.Commands[0]["publish"]))
+
+		_, _ = w.Write([]byte(`{"replies":[{"id":1,"publish":{"offset":1}},{"id":2,"error":{"code":107,"message":"bad request"}}]}`))
+	})
+
+	resp, err := api.Batch(t.Context(), &v1Client.BatchRequest{Commands: []*v1Client.Command{
+		{Id: 1, Publish: &v1Client.PublishRequest{Channel: "a", Data: []byte(`[1,2]`)}},
+		{Id: 2, Publish: &v1Client.PublishRequest{Channel: "b", Data: []byte(`{}`)}},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetReplies(), 2)
+	assert.Equal(t, uint64(1), resp.GetReplies()[0].GetPublish().GetOffset())
+	assert.Equal(t, uint32(107), resp.GetReplies()[1].GetError().GetCode())
+}
+
+func TestHTTPAPIClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"result":{"offset":7}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := newClient("", nil, testLogger(), false)
+	c.httpAPI = &HTTPAPI{Address: srv.URL, MaxIdleConns: 1, IdleTimeout: time.Second}
+	require.NoError(t, c.connect())
+
+	resp, err := c.publish(t.Context(), &v1Client.PublishRequest{Channel: "news", Data: []byte(`{}`)})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), resp.GetResult().GetOffset())
+}
+
+func TestAPIMethod(t *testing.T) {
+	for method, want := range map[string]string{
+		"/centrifugal.centrifugo.api.CentrifugoApi/Publish":              "publish",
+		"/centrifugal.centrifugo.api.CentrifugoApi/PresenceStats":        "presence_stats",
+		"/centrifugal.centrifugo.api.CentrifugoApi/RPC":                  "rpc",
+		"/centrifugal.centrifugo.api.CentrifugoApi/SendPushNotification": "send_push_notification",
+		"/centrifugal.centrifugo.api.CentrifugoApi/HistoryRemove":        "history_remove",
+	} {
+		assert.Equal(t, want, apiMethod(method), method)
+	}
+}
@@ -0,0 +1,281 @@
+package centrifuge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	stderr "errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpProxyPaths maps the HTTP proxy endpoints to the proxy types
+func httpProxyPaths() map[string]string {
+	return map[string]string{
+		"/connect":              connectType,
+		"/refresh":              refreshType,
+		"/subscribe":            subscribeType,
+		"/publish":              publishType,
+		"/rpc":                  rpcType,
+		"/sub_refresh":          subRefreshType,
+		"/notify_cache_empty":   notifyCacheEmptyType,
+		"/notify_channel_state": notifyChannelStateType,
+	}
+}
+
+// httpProxy accepts Centrifugo HTTP proxy requests and dispatches them through the same
+// middleware chain and worker pool as the gRPC proxy. Centrifugo sends the forwarded
+// headers as HTTP headers, they are passed to the workers as metadata.
+type httpProxy struct {
+	log     *slog.Logger
+	proxy   *Proxy
+	maxSize int64
+	mux     *http.ServeMux
+}
+
+func newHTTPProxy(proxy *Proxy, maxSize int64, log *slog.Logger) *httpProxy {
+	h := &httpProxy{
+		log:     log,
+		proxy:   proxy,
+		maxSize: maxSize,
+		mux:     http.NewServeMux(),
+	}
+
+	for path, typ := range httpProxyPaths() {
+		h.mux.HandleFunc("POST "+path, func(w http.ResponseWriter, r *http.Request) {
+			h.handle(w, r, typ)
+		})
+	}
+
+	return h
+}
+
+func (h *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *httpProxy) handle(w http.ResponseWriter, r *http.Request, typ string) {
+	h.log.Debug("got http proxy request", "type", typ)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	req, resp, _ := proxyMessages(typ)
+
+	body, err = rawToProtoJSON(req.ProtoReflect().Descriptor(), body)
+	if err == nil {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, req)
+	}
+	if err != nil {
+		http.Error(w, "invalid "+typ+" proxy request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	md := metadata.MD{}
+	for k, v := range r.Header {
+		md[strings.ToLower(k)] = v
+	}
+
+	out, err := dispatch(metadata.NewIncomingContext(r.Context(), md), h.proxy, typ, req, resp)
+	if err != nil {
+		h.log.Error("http proxy request failed", "type", typ, "error", err)
+		http.Error(w, err.Error(), httpStatus(err))
+
+		return
+	}
+
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(out)
+	if err == nil {
+		data, err = protoJSONToRaw(out.ProtoReflect().Descriptor(), data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// httpStatus maps the dispatch errors to the HTTP status codes.
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// converter converts a JSON value of a scalar field
+type converter func(fd protoreflect.FieldDescriptor, v json.RawMessage) (json.RawMessage, error)
+
+// rawToProtoJSON converts the raw JSON values Centrifugo sends in the bytes fields
+// (data, info, meta) into the base64 strings expected by protojson.
+func rawToProtoJSON(md protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	return convertFields(md, data, func(fd protoreflect.FieldDescriptor, v json.RawMessage) (json.RawMessage, error) {
+		if fd.Kind() != protoreflect.BytesKind {
+			return v, nil
+		}
+
+		return json.Marshal(base64.StdEncoding.EncodeToString(v))
+	})
+}
+
+// errBinaryValue is returned by the protoJSONToRaw converter for the bytes which are not a JSON document
+var errBinaryValue = stderr.New("binary value is not a JSON document")
+
+// protoJSONToRaw converts the base64 encoded bytes fields produced by protojson back into raw
+// JSON values. The values that are not JSON documents are moved base64 encoded into the Centrifugo
+// b64 fields (data to b64data, info to b64info), the other bytes fields must hold JSON documents.
+// The 64-bit integers, encoded by protojson as strings, are converted back to numbers.
+func protoJSONToRaw(md protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	return convertFields(md, data, func(fd protoreflect.FieldDescriptor, v json.RawMessage) (json.RawMessage, error) {
+		switch fd.Kind() { //nolint:exhaustive
+		case protoreflect.BytesKind:
+			var s string
+			err := json.Unmarshal(v, &s)
+			if err != nil {
+				return nil, err
+			}
+
+			raw, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+
+			if !json.Valid(raw) {
+				return nil, errBinaryValue
+			}
+
+			return raw, nil
+		case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+			protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			var n json.Number
+			err := json.Unmarshal(v, &n)
+			if err != nil {
+				return nil, err
+			}
+
+			return json.RawMessage(n), nil
+		default:
+			return v, nil
+		}
+	})
+}
+
+// convertFields applies conv to every scalar field value of the JSON object data described by md.
+func convertFields(md protoreflect.MessageDescriptor, data []byte, conv converter) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+
+	renamed := make(map[string]string)
+	for k, v := range obj {
+		fd := md.Fields().ByName(protoreflect.Name(k))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(k)
+		}
+
+		if fd == nil || string(v) == "null" {
+			continue
+		}
+
+		obj[k], err = convertValue(fd, v, conv)
+		if stderr.Is(err, errBinaryValue) && b64Field(fd) != "" {
+			// the base64 encoded value as is
+			obj[k] = v
+			renamed[k] = b64Field(fd)
+
+			continue
+		}
+		if err != nil {
+			return nil, errors.Errorf("%s: %v", k, err)
+		}
+	}
+
+	for k, b64 := range renamed {
+		obj[b64] = obj[k]
+		delete(obj, k)
+	}
+
+	return json.Marshal(obj)
+}
+
+// b64Field returns the Centrifugo field carrying the binary value of a JSON bytes field, e.g. b64data.
+func b64Field(fd protoreflect.FieldDescriptor) string {
+	if fd.Kind() != protoreflect.BytesKind || fd.IsList() {
+		return ""
+	}
+
+	switch fd.Name() {
+	case "data", "info":
+		return "b64" + string(fd.Name())
+	default:
+		return ""
+	}
+}
+
+func convertValue(fd protoreflect.FieldDescriptor, v json.RawMessage, conv converter) (json.RawMessage, error) {
+	switch {
+	case fd.IsMap():
+		if fd.MapValue().Kind() != protoreflect.MessageKind {
+			return v, nil
+		}
+
+		var m map[string]json.RawMessage
+		err := json.Unmarshal(v, &m)
+		if err != nil {
+			return nil, err
+		}
+
+		for mk, mv := range m {
+			m[mk], err = convertFields(fd.MapValue().Message(), mv, conv)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return json.Marshal(m)
+	case fd.IsList():
+		var l []json.RawMessage
+		err := json.Unmarshal(v, &l)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range l {
+			l[i], err = convertScalar(fd, l[i], conv)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return json.Marshal(l)
+	default:
+		return convertScalar(fd, v, conv)
+	}
+}
+
+func convertScalar(fd protoreflect.FieldDescriptor, v json.RawMessage, conv converter) (json.RawMessage, error) {
+	if fd.Kind() == protoreflect.MessageKind {
+		return convertFields(fd.Message(), v, conv)
+	}
+
+	return conv(fd, v)
+}
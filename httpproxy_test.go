@@ -0,0 +1,142 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestHTTPProxy(t *testing.T, h Handler) *httptest.Server {
+	t.Helper()
+
+	p := newTestProxy()
+	p.handler = h
+
+	srv := httptest.NewServer(newHTTPProxy(p, 1024*1024, testLogger()))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func post(t *testing.T, url, body string, hdr http.Header) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header = hdr
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(b)
+}
+
+func TestHTTPProxyConnect(t *testing.T) {
+	srv := newTestHTTPProxy(t, func(ctx context.Context, req *Request) (proto.Message, error) {
+		assert.Equal(t, connectType, req.Type)
+
+		cr := req.Message.(*centrifugov1.ConnectRequest)
+		assert.Equal(t, "c1", cr.GetClient())
+		assert.JSONEq(t, `{"token":"t"}`, string(cr.GetData()))
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		assert.Equal(t, []string{"session=1"}, md.Get("cookie"))
+
+		return &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{
+			User:     "u1",
+			ExpireAt: 100,
+			Info:     []byte(`{"name":"Alex"}`),
+			Subs: map[string]*centrifugov1.SubscribeOptions{
+				"news": {Data: []byte(`{"a":1}`)},
+			},
+		}}, nil
+	})
+
+	code, body := post(t, srv.URL+"/connect",
+		`{"client":"c1","transport":"websocket","protocol":"json","encoding":"json","data":{"token":"t"},"unknown":1}`,
+		http.Header{"Cookie": {"session=1"}})
+	require.Equal(t, http.StatusOK, code, body)
+
+	assert.JSONEq(t, `{"result":{"user":"u1","expire_at":100,"info":{"name":"Alex"},"subs":{"news":{"data":{"a":1}}}}}`, body)
+}
+
+func TestHTTPProxyDenied(t *testing.T) {
+	srv := newTestHTTPProxy(t, func(_ context.Context, req *Request) (proto.Message, error) {
+		assert.Equal(t, subRefreshType, req.Type)
+
+		return &centrifugov1.SubRefreshResponse{Error: &centrifugov1.Error{Code: 103, Message: "permission denied"}}, nil
+	})
+
+	code, body := post(t, srv.URL+"/sub_refresh", `{"client":"c1","channel":"news"}`, nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"error":{"code":103,"message":"permission denied"}}`, body)
+}
+
+func TestHTTPProxyErrors(t *testing.T) {
+	var fail error
+	srv := newTestHTTPProxy(t, func(_ context.Context, req *Request) (proto.Message, error) {
+		return req.NewResponse(), fail
+	})
+
+	code, _ := post(t, srv.URL+"/connect", `not json`, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	resp, err := http.Get(srv.URL + "/connect") //nolint:noctx
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	code, _ = post(t, srv.URL+"/unknown", `{}`, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	fail = status.Error(codes.Unavailable, "circuit breaker is open")
+	code, _ = post(t, srv.URL+"/rpc", `{"method":"m"}`, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	fail = errors.New("worker empty response")
+	code, _ = post(t, srv.URL+"/rpc", `{"method":"m"}`, nil)
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestProtoJSONToRawBinary(t *testing.T) {
+	out, err := protoJSONToRaw((&centrifugov1.RPCResult{}).ProtoReflect().Descriptor(), []byte(`{"data":"AAE="}`))
+	require.NoError(t, err)
+
+	// not a JSON document, moved base64 encoded into the Centrifugo binary field
+	assert.JSONEq(t, `{"b64data":"AAE="}`, string(out))
+
+	out, err = protoJSONToRaw((&centrifugov1.ConnectResult{}).ProtoReflect().Descriptor(),
+		[]byte(`{"data":"e30=","info":"AAE=","subs":{"news":{"data":"AAE="}}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data":{},"b64info":"AAE=","subs":{"news":{"b64data":"AAE="}}}`, string(out))
+
+	// no binary counterpart
+	_, err = protoJSONToRaw((&centrifugov1.ConnectResult{}).ProtoReflect().Descriptor(), []byte(`{"meta":"AAE="}`))
+	require.Error(t, err)
+}
+
+func TestHTTPProxyBinaryResponse(t *testing.T) {
+	srv := newTestHTTPProxy(t, func(context.Context, *Request) (proto.Message, error) {
+		return &centrifugov1.RPCResponse{Result: &centrifugov1.RPCResult{Data: []byte{0, 1}}}, nil
+	})
+
+	code, body := post(t, srv.URL+"/rpc", `{"method":"m","data":{}}`, nil)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.JSONEq(t, `{"result":{"b64data":"AAE="}}`, body)
+}
@@ -0,0 +1,185 @@
+package centrifuge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// prefix of the generated idempotency keys
+const idempotencyKeyPrefix string = "rr-"
+
+// idempotency makes the Publish and Broadcast RPC calls safe to repeat. Every call carries an idempotency
+// key, so Centrifugo drops the repeated submissions, and the calls failed with a transport error are sent
+// again with the same key. The key is the caller one, derived from the content if deriveKeys is set, or
+// else a random key of the call. The successful responses of the calls with a caller or derived key are
+// kept for the window, a repeated call with the same key gets the kept response without reaching Centrifugo.
+type idempotency struct {
+	mu         sync.Mutex
+	log        *slog.Logger
+	window     time.Duration
+	retries    int
+	backoff    time.Duration
+	deriveKeys bool
+
+	seen      map[string]*seenCall
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type seenCall struct {
+	resp    proto.Message
+	expires time.Time
+}
+
+func newIdempotency(cfg *Idempotency, log *slog.Logger) *idempotency {
+	return &idempotency{
+		log:        log,
+		window:     cfg.Window,
+		retries:    max(cfg.Retries, 0),
+		backoff:    cfg.Backoff,
+		deriveKeys: cfg.DeriveKeys,
+		seen:       make(map[string]*seenCall),
+		now:        time.Now,
+	}
+}
+
+// publishKey returns the idempotency key of the publish call and whether its response is kept for the window.
+func (i *idempotency) publishKey(in *v1Client.PublishRequest) (string, bool) {
+	switch {
+	case in.GetIdempotencyKey() != "":
+		return in.GetIdempotencyKey(), true
+	case i.deriveKeys:
+		return derivedPublishKey(in), true
+	default:
+		return callKey(), false
+	}
+}
+
+// broadcastKey returns the idempotency key of the broadcast call and whether its response is kept for the window.
+func (i *idempotency) broadcastKey(in *v1Client.BroadcastRequest) (string, bool) {
+	switch {
+	case in.GetIdempotencyKey() != "":
+		return in.GetIdempotencyKey(), true
+	case i.deriveKeys:
+		return derivedBroadcastKey(in), true
+	default:
+		return callKey(), false
+	}
+}
+
+// callKey returns a random key, only the retries of the call share it. Centrifugo drops the publications
+// repeating a key for its idempotent result TTL, the later identical publications are not dropped then.
+func callKey() string {
+	return idempotencyKeyPrefix + uuid.NewString()
+}
+
+// derivedPublishKey returns a key derived from the channel and the data.
+func derivedPublishKey(in *v1Client.PublishRequest) string {
+	h := sha256.New()
+	writeField(h, in.GetChannel())
+	writeField(h, string(in.GetData()))
+	writeField(h, in.GetB64Data())
+
+	return idempotencyKeyPrefix + hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// derivedBroadcastKey returns a key derived from the channels and the data.
+func derivedBroadcastKey(in *v1Client.BroadcastRequest) string {
+	h := sha256.New()
+	for _, ch := range in.GetChannels() {
+		writeField(h, ch)
+	}
+	writeField(h, string(in.GetData()))
+	writeField(h, in.GetB64Data())
+
+	return idempotencyKeyPrefix + hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// writeField writes a length-prefixed field, so the field boundaries are part of the hash.
+func writeField(h hash.Hash, s string) {
+	_ = binary.Write(h, binary.BigEndian, uint64(len(s)))
+	_, _ = h.Write([]byte(s))
+}
+
+func (i *idempotency) lookup(key string) proto.Message {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	sc, ok := i.seen[key]
+	if !ok || i.now().After(sc.expires) {
+		return nil
+	}
+
+	return proto.Clone(sc.resp)
+}
+
+func (i *idempotency) remember(key string, resp proto.Message) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := i.now()
+	i.seen[key] = &seenCall{resp: proto.Clone(resp), expires: now.Add(i.window)}
+
+	if now.Sub(i.lastSweep) < i.window {
+		return
+	}
+
+	i.lastSweep = now
+	for k, sc := range i.seen {
+		if now.After(sc.expires) {
+			delete(i.seen, k)
+		}
+	}
+}
+
+// idempotentCall sends the call unless a response with the same key is kept and repeats it after the
+// transport errors. The Centrifugo errors are responses and are not repeated. The successful response
+// is kept if keep is set.
+func idempotentCall[T interface {
+	proto.Message
+	GetError() *v1Client.Error
+}](ctx context.Context, i *idempotency, key string, keep bool, call func() (T, error)) (T, error) {
+	if !keep {
+		return retryCall(ctx, i, key, call)
+	}
+
+	if kept, ok := i.lookup(key).(T); ok {
+		i.log.Debug("duplicate call suppressed", "idempotency_key", key)
+		return kept, nil
+	}
+
+	resp, err := retryCall(ctx, i, key, call)
+	if err == nil && resp.GetError() == nil {
+		i.remember(key, resp)
+	}
+
+	return resp, err
+}
+
+// retryCall repeats the call after the transport errors.
+func retryCall[T proto.Message](ctx context.Context, i *idempotency, key string, call func() (T, error)) (T, error) {
+	resp, err := call()
+	for attempt := 1; err != nil && attempt <= i.retries; attempt++ {
+		i.log.Warn("centrifugo api call failed, retrying", "idempotency_key", key, "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(i.backoff):
+		}
+
+		resp, err = call()
+	}
+
+	return resp, err
+}
@@ -0,0 +1,171 @@
+package centrifuge
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	a := derivedPublishKey(&v1Client.PublishRequest{Channel: "news", Data: []byte(`{"a":1}`)})
+	assert.Equal(t, a, derivedPublishKey(&v1Client.PublishRequest{Channel: "news", Data: []byte(`{"a":1}`)}))
+	assert.NotEqual(t, a, derivedPublishKey(&v1Client.PublishRequest{Channel: "news", Data: []byte(`{"a":2}`)}))
+	assert.NotEqual(t, a, derivedPublishKey(&v1Client.PublishRequest{Channel: "sport", Data: []byte(`{"a":1}`)}))
+	// the field boundaries are hashed
+	assert.NotEqual(t,
+		derivedPublishKey(&v1Client.PublishRequest{Channel: "ab", Data: []byte("c")}),
+		derivedPublishKey(&v1Client.PublishRequest{Channel: "a", Data: []byte("bc")}))
+
+	b := derivedBroadcastKey(&v1Client.BroadcastRequest{Channels: []string{"a", "b"}, Data: []byte("x")})
+	assert.NotEqual(t, b, derivedBroadcastKey(&v1Client.BroadcastRequest{Channels: []string{"ab"}, Data: []byte("x")}))
+
+	// a random key of the call by default, the response is not kept
+	i := newIdempotency(&Idempotency{}, testLogger())
+	pub := &v1Client.PublishRequest{Channel: "news", Data: []byte(`{"a":1}`)}
+	k1, keep := i.publishKey(pub)
+	assert.False(t, keep)
+	k2, _ := i.publishKey(pub)
+	assert.NotEqual(t, k1, k2)
+	k1, _ = i.broadcastKey(&v1Client.BroadcastRequest{Channels: []string{"a"}})
+	assert.NotEqual(t, k1, k2)
+
+	k, keep := i.publishKey(&v1Client.PublishRequest{Channel: "news", IdempotencyKey: "my-key"})
+	assert.Equal(t, "my-key", k)
+	assert.True(t, keep)
+	k, keep = i.broadcastKey(&v1Client.BroadcastRequest{Channels: []string{"a"}, IdempotencyKey: "my-key"})
+	assert.Equal(t, "my-key", k)
+	assert.True(t, keep)
+
+	// opted in
+	i = newIdempotency(&Idempotency{DeriveKeys: true}, testLogger())
+	k, keep = i.publishKey(pub)
+	assert.Equal(t, a, k)
+	assert.True(t, keep)
+	k, keep = i.broadcastKey(&v1Client.BroadcastRequest{Channels: []string{"a", "b"}, Data: []byte("x")})
+	assert.Equal(t, b, k)
+	assert.True(t, keep)
+}
+
+func TestIdempotentCall(t *testing.T) {
+	i := newIdempotency(&Idempotency{Window: time.Minute, Retries: 2, Backoff: time.Millisecond}, testLogger())
+	now := time.Now()
+	i.now = func() time.Time { return now }
+
+	var calls int
+	call := func() (*v1Client.PublishResponse, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("connection reset")
+		}
+
+		return &v1Client.PublishResponse{Result: &v1Client.PublishResult{Offset: 10}}, nil
+	}
+
+	resp, err := idempotentCall(t.Context(), i, "k1", true, call)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), resp.GetResult().GetOffset())
+	assert.Equal(t, 3, calls)
+
+	// a duplicate within the window gets the kept response
+	resp, err = idempotentCall(t.Context(), i, "k1", true, call)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), resp.GetResult().GetOffset())
+	assert.Equal(t, 3, calls)
+
+	// and is sent again after the window
+	now = now.Add(time.Minute * 2)
+	_, err = idempotentCall(t.Context(), i, "k1", true, call)
+	require.NoError(t, err)
+	assert.Equal(t, 4, calls)
+
+	// the response of a call with a random key is not kept
+	_, err = idempotentCall(t.Context(), i, "k2", false, call)
+	require.NoError(t, err)
+	_, err = idempotentCall(t.Context(), i, "k2", false, call)
+	require.NoError(t, err)
+	assert.Equal(t, 6, calls)
+}
+
+func TestIdempotentCallFailures(t *testing.T) {
+	i := newIdempotency(&Idempotency{Window: time.Minute, Retries: 1, Backoff: time.Millisecond}, testLogger())
+
+	var calls int
+	_, err := idempotentCall(t.Context(), i, "k1", true, func() (*v1Client.PublishResponse, error) {
+		calls++
+		return nil, errors.New("connection refused")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 2, calls)
+
+	// the Centrifugo errors are neither repeated nor kept
+	calls = 0
+	rejected := func() (*v1Client.PublishResponse, error) {
+		calls++
+		return &v1Client.PublishResponse{Error: &v1Client.Error{Code: 102, Message: "unknown channel"}}, nil
+	}
+
+	for range 2 {
+		resp, errC := idempotentCall(t.Context(), i, "k2", true, rejected)
+		require.NoError(t, errC)
+		assert.Equal(t, uint32(102), resp.GetError().GetCode())
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotentPublishRPC(t *testing.T) {
+	var requests atomic.Int32
+	keys := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		var req struct {
+			IdempotencyKey string `json:"idempotency_key"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.NotEmpty(t, req.IdempotencyKey)
+		keys <- req.IdempotencyKey
+
+		_, _ = w.Write([]byte(`{"result":{"offset":1}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := newClient("", nil, testLogger(), false)
+	c.httpAPI = &HTTPAPI{Address: srv.URL, MaxIdleConns: 1, IdleTimeout: time.Second}
+	require.NoError(t, c.connect())
+
+	// identical publications are sent with own keys by default
+	p := &Plugin{idempotency: newIdempotency(&Idempotency{Window: time.Minute, Retries: 1, Backoff: time.Millisecond}, testLogger())}
+	r := &rpc{client: c, plugin: p, log: testLogger()}
+
+	for range 2 {
+		out := &v1Client.PublishResponse{}
+		require.NoError(t, r.Publish(&v1Client.PublishRequest{Channel: "news", Data: []byte(`{"ping":1}`)}, out))
+		assert.Equal(t, uint64(1), out.GetResult().GetOffset())
+	}
+
+	assert.Equal(t, int32(2), requests.Load())
+	assert.NotEqual(t, <-keys, <-keys)
+
+	// the derived keys drop them
+	requests.Store(0)
+	p.idempotency = newIdempotency(&Idempotency{Window: time.Minute, Retries: 1, Backoff: time.Millisecond, DeriveKeys: true}, testLogger())
+
+	for range 3 {
+		out := &v1Client.PublishResponse{}
+		require.NoError(t, r.Publish(&v1Client.PublishRequest{Channel: "news", Data: []byte(`{"a":1}`)}, out))
+		assert.Equal(t, uint64(1), out.GetResult().GetOffset())
+	}
+
+	out := &v1Client.BroadcastResponse{}
+	require.NoError(t, r.Broadcast(&v1Client.BroadcastRequest{Channels: []string{"news"}, Data: []byte(`{"a":1}`)}, out))
+
+	assert.Equal(t, int32(2), requests.Load())
+}
@@ -0,0 +1,80 @@
+package centrifuge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/tcplisten"
+)
+
+// createListener creates an additional proxy listener. TLS is terminated by the listener,
+// so every listener of the shared gRPC server has its own TLS settings.
+func createListener(cfg *Listener) (net.Listener, error) {
+	const op = errors.Op("centrifuge_create_listener")
+
+	var tlsCfg *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		tlsCfg, err = cfg.TLS.config()
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	l, err := tcplisten.CreateListener(cfg.Address)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if path, ok := strings.CutPrefix(cfg.Address, "unix://"); ok && cfg.Permissions != "" {
+		mode, _ := strconv.ParseUint(cfg.Permissions, 8, 32)
+
+		err = os.Chmod(path, os.FileMode(mode))
+		if err != nil {
+			_ = l.Close()
+			return nil, errors.E(op, err)
+		}
+	}
+
+	if tlsCfg != nil {
+		return tls.NewListener(l, tlsCfg), nil
+	}
+
+	return l, nil
+}
+
+func (t *ListenerTLS) config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// gRPC requires HTTP/2
+		NextProtos: []string{"h2"},
+	}
+
+	if t.ClientCA != "" {
+		ca, err := os.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificates found in the client CA file '%s'", t.ClientCA)
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
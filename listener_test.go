@@ -0,0 +1,125 @@
+package centrifuge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and returns the cert and key paths.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	cert, keyPath := filepath.Join(dir, "test.crt"), filepath.Join(dir, "test.key")
+	require.NoError(t, os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0o600))
+
+	return cert, keyPath
+}
+
+// serveHealth serves the health service on l and returns a health client dialed with creds.
+func serveHealth(t *testing.T, l net.Listener, target string, creds credentials.TransportCredentials) healthv1.HealthClient {
+	t.Helper()
+
+	srv := grpc.NewServer()
+	healthv1.RegisterHealthServer(srv, health.NewServer())
+
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return healthv1.NewHealthClient(conn)
+}
+
+func TestListenerUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "proxy.sock")
+
+	l, err := createListener(&Listener{Address: "unix://" + sock, Permissions: "0600"})
+	require.NoError(t, err)
+
+	st, err := os.Stat(sock)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+	hc := serveHealth(t, l, "unix://"+sock, insecure.NewCredentials())
+	_, err = hc.Check(t.Context(), &healthv1.HealthCheckRequest{})
+	require.NoError(t, err)
+}
+
+func TestListenerTLS(t *testing.T) {
+	cert, key := writeTestCert(t, t.TempDir())
+
+	l, err := createListener(&Listener{Address: "tcp://127.0.0.1:0", TLS: &ListenerTLS{Cert: cert, Key: key, ClientCA: cert}})
+	require.NoError(t, err)
+
+	ca, err := os.ReadFile(cert)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(ca))
+
+	clientCert, err := tls.LoadX509KeyPair(cert, key)
+	require.NoError(t, err)
+
+	hc := serveHealth(t, l, l.Addr().String(), credentials.NewTLS(&tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+		MinVersion:   tls.VersionTLS12,
+	}))
+	_, err = hc.Check(t.Context(), &healthv1.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	// no client certificate
+	hc = serveHealth(t, l, l.Addr().String(), credentials.NewTLS(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}))
+	_, err = hc.Check(t.Context(), &healthv1.HealthCheckRequest{})
+	require.Error(t, err)
+}
+
+func TestListenerInvalidTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeTestCert(t, dir)
+
+	_, err := createListener(&Listener{Address: "tcp://127.0.0.1:0", TLS: &ListenerTLS{Cert: key, Key: cert}})
+	require.Error(t, err)
+}
@@ -1,19 +1,28 @@
 package centrifuge
 
 import (
+	"context"
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/roadrunner-server/pool/v2/fsm"
 	"github.com/roadrunner-server/pool/v2/state/process"
+	"google.golang.org/protobuf/proto"
 )
 
+const metricsMiddleware string = "metrics"
+
 type Informer interface {
 	Workers() []*process.State
 }
 
 func (p *Plugin) MetricsCollector() []prometheus.Collector {
-	return []prometheus.Collector{p.statsExporter}
+	if p.proxyMetrics == nil {
+		return []prometheus.Collector{p.statsExporter}
+	}
+
+	return []prometheus.Collector{p.statsExporter, p.proxyMetrics}
 }
 
 func newWorkersExporter(stats Informer) *StatsExporter {
@@ -93,3 +102,162 @@ func (s *StatsExporter) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(s.TotalWorkersDesc, prometheus.GaugeValue, float64(len(workerStates)))
 	ch <- prometheus.MustNewConstMetric(s.TotalMemoryDesc, prometheus.GaugeValue, cum)
 }
+
+// proxyMetrics is the built-in `metrics` proxy middleware, it counts proxy calls per type and result.
+// It also holds the slow requests counter, which is reported by the Proxy regardless of the middleware list.
+type proxyMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	slow     *prometheus.CounterVec
+	// shadow pool comparisons
+	shadowRequests    *prometheus.CounterVec
+	shadowDivergences *prometheus.CounterVec
+	// canary routing
+	poolRequests *prometheus.CounterVec
+	canaryWeight prometheus.Gauge
+	// circuit breakers
+	circuitState       *prometheus.GaugeVec
+	circuitTransitions *prometheus.CounterVec
+	circuitRejected    *prometheus.CounterVec
+	// retries of failed worker executions
+	retries *prometheus.CounterVec
+	// enabled proxy types
+	enabled  *prometheus.GaugeVec
+	disabled *prometheus.CounterVec
+	// channel occupancy registry
+	occupiedChannels prometheus.Gauge
+	// asynchronous publish queue
+	outboxDepth   prometheus.Gauge
+	outboxAge     prometheus.Gauge
+	outboxDropped *prometheus.CounterVec
+}
+
+func newProxyMetrics() *proxyMetrics {
+	return &proxyMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_requests_total",
+			Help: "Total number of Centrifugo proxy requests by type and result",
+		}, []string{"type", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rr_centrifugo_proxy_request_duration_seconds",
+			Help:    "Centrifugo proxy request duration",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"type"}),
+		slow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_slow_requests_total",
+			Help: "Total number of Centrifugo proxy requests whose worker execution exceeded the slow threshold",
+		}, []string{"type"}),
+		shadowRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_shadow_requests_total",
+			Help: "Total number of Centrifugo proxy requests mirrored to the shadow pool by type and comparison result",
+		}, []string{"type", "result"}),
+		shadowDivergences: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_shadow_divergences_total",
+			Help: "Total number of differences between the primary and shadow pool responses by type and reason",
+		}, []string{"type", "reason"}),
+		poolRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_pool_requests_total",
+			Help: "Total number of canary routed Centrifugo proxy requests by pool, type and result",
+		}, []string{"pool", "type", "result"}),
+		canaryWeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rr_centrifugo_proxy_canary_weight",
+			Help: "Percentage of the Centrifugo proxy requests routed to the canary pool",
+		}),
+		circuitState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rr_centrifugo_proxy_circuit_state",
+			Help: "Centrifugo proxy circuit breaker state by type: 0 closed, 1 open, 2 half-open",
+		}, []string{"type"}),
+		circuitTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_circuit_transitions_total",
+			Help: "Total number of Centrifugo proxy circuit breaker state changes by type and new state",
+		}, []string{"type", "state"}),
+		circuitRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_circuit_rejected_total",
+			Help: "Total number of Centrifugo proxy requests answered with the fallback while the circuit was open",
+		}, []string{"type"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_retries_total",
+			Help: "Total number of Centrifugo proxy request retries after a worker failure by type and result",
+		}, []string{"type", "result"}),
+		enabled: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rr_centrifugo_proxy_enabled",
+			Help: "Centrifugo proxy types served by the workers: 1 enabled, 0 disabled",
+		}, []string{"type"}),
+		disabled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_proxy_disabled_requests_total",
+			Help: "Total number of Centrifugo proxy requests of the disabled types answered without a worker",
+		}, []string{"type"}),
+		occupiedChannels: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rr_centrifugo_occupied_channels",
+			Help: "Number of the channels reported as occupied by the Centrifugo channel state events",
+		}),
+		outboxDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rr_centrifugo_outbox_depth",
+			Help: "Number of the asynchronous publish and broadcast commands waiting for the delivery",
+		}),
+		outboxAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rr_centrifugo_outbox_oldest_age_seconds",
+			Help: "Age of the oldest queued asynchronous command",
+		}),
+		outboxDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rr_centrifugo_outbox_dropped_total",
+			Help: "Total number of the asynchronous commands dropped by reason: full, attempts or rejected",
+		}, []string{"reason"}),
+	}
+}
+
+func (m *proxyMetrics) Name() string {
+	return metricsMiddleware
+}
+
+func (m *proxyMetrics) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		start := time.Now()
+		resp, err := next(ctx, req)
+
+		m.duration.WithLabelValues(req.Type).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(req.Type, outcome(resp, err)).Inc()
+
+		return resp, err
+	}
+}
+
+func (m *proxyMetrics) Describe(d chan<- *prometheus.Desc) {
+	m.requests.Describe(d)
+	m.duration.Describe(d)
+	m.slow.Describe(d)
+	m.shadowRequests.Describe(d)
+	m.shadowDivergences.Describe(d)
+	m.poolRequests.Describe(d)
+	m.canaryWeight.Describe(d)
+	m.circuitState.Describe(d)
+	m.circuitTransitions.Describe(d)
+	m.circuitRejected.Describe(d)
+	m.retries.Describe(d)
+	m.enabled.Describe(d)
+	m.disabled.Describe(d)
+	m.occupiedChannels.Describe(d)
+	m.outboxDepth.Describe(d)
+	m.outboxAge.Describe(d)
+	m.outboxDropped.Describe(d)
+}
+
+func (m *proxyMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.duration.Collect(ch)
+	m.slow.Collect(ch)
+	m.shadowRequests.Collect(ch)
+	m.shadowDivergences.Collect(ch)
+	m.poolRequests.Collect(ch)
+	m.canaryWeight.Collect(ch)
+	m.circuitState.Collect(ch)
+	m.circuitTransitions.Collect(ch)
+	m.circuitRejected.Collect(ch)
+	m.retries.Collect(ch)
+	m.enabled.Collect(ch)
+	m.disabled.Collect(ch)
+	m.occupiedChannels.Collect(ch)
+	m.outboxDepth.Collect(ch)
+	m.outboxAge.Collect(ch)
+	m.outboxDropped.Collect(ch)
+}
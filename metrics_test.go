@@ -1,12 +1,16 @@
 package centrifuge
 
 import (
+	"context"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
 	"github.com/roadrunner-server/pool/v2/fsm"
 	"github.com/roadrunner-server/pool/v2/state/process"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 type fakeInformer struct {
@@ -18,7 +22,7 @@ func (f *fakeInformer) Workers() []*process.State { return f.states }
 // collectCount registers exp in a fresh registry and returns the number of
 // gathered samples. Gather rejects duplicate label sets, which is why the
 // states below must carry distinct PIDs.
-func collectCount(t *testing.T, exp *StatsExporter) int {
+func collectCount(t *testing.T, exp prometheus.Collector) int {
 	t.Helper()
 
 	reg := prometheus.NewRegistry()
@@ -35,6 +39,16 @@ func collectCount(t *testing.T, exp *StatsExporter) int {
 	return n
 }
 
+// counterValue returns the current value of a single counter.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	require.NoError(t, c.Write(m))
+
+	return m.GetCounter().GetValue()
+}
+
 func TestStatsExporterCollect(t *testing.T) {
 	// Distinct PIDs avoid duplicate label sets; the three statuses also cover the
 	// ready/working/default arms of the status switch in Collect.
@@ -72,3 +86,19 @@ func TestPluginMetricsCollector(t *testing.T) {
 
 	require.Len(t, p.MetricsCollector(), 1)
 }
+
+func TestProxyMetricsMiddleware(t *testing.T) {
+	m := newProxyMetrics()
+
+	h := m.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		return req.NewResponse(), nil
+	})
+
+	_, err := h(t.Context(), &Request{Type: connectType, response: &centrifugov1.ConnectResponse{}})
+	require.NoError(t, err)
+
+	// one requests_total series, one duration histogram and the canary weight, occupied channels
+	// and outbox depth and age gauges
+	require.Equal(t, 6, collectCount(t, m))
+	require.Len(t, (&Plugin{statsExporter: newWorkersExporter(&fakeInformer{}), proxyMetrics: m}).MetricsCollector(), 2)
+}
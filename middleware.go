@@ -0,0 +1,181 @@
+package centrifuge
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// proxy types, sent to the worker in the payload context under the `type` key
+const (
+	connectType            string = "connect"
+	refreshType            string = "refresh"
+	subscribeType          string = "subscribe"
+	publishType            string = "publish"
+	rpcType                string = "rpc"
+	subRefreshType         string = "subrefresh"
+	notifyCacheEmptyType   string = "notifycacheempty"
+	notifyChannelStateType string = "notifychannelstate"
+)
+
+// proxyTypes returns all proxy types handled by the plugin
+func proxyTypes() []string {
+	return []string{
+		connectType,
+		refreshType,
+		subscribeType,
+		publishType,
+		rpcType,
+		subRefreshType,
+		notifyCacheEmptyType,
+		notifyChannelStateType,
+	}
+}
+
+// proxyMessages returns an empty typed Centrifugo request and response for the proxy type.
+func proxyMessages(typ string) (proto.Message, proto.Message, bool) {
+	switch typ {
+	case connectType:
+		return &centrifugov1.ConnectRequest{}, &centrifugov1.ConnectResponse{}, true
+	case refreshType:
+		return &centrifugov1.RefreshRequest{}, &centrifugov1.RefreshResponse{}, true
+	case subscribeType:
+		return &centrifugov1.SubscribeRequest{}, &centrifugov1.SubscribeResponse{}, true
+	case publishType:
+		return &centrifugov1.PublishRequest{}, &centrifugov1.PublishResponse{}, true
+	case rpcType:
+		return &centrifugov1.RPCRequest{}, &centrifugov1.RPCResponse{}, true
+	case subRefreshType:
+		return &centrifugov1.SubRefreshRequest{}, &centrifugov1.SubRefreshResponse{}, true
+	case notifyCacheEmptyType:
+		return &centrifugov1.NotifyCacheEmptyRequest{}, &centrifugov1.NotifyCacheEmptyResponse{}, true
+	case notifyChannelStateType:
+		return &centrifugov1.NotifyChannelStateRequest{}, &centrifugov1.NotifyChannelStateResponse{}, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// proxy call results, see outcome
+const (
+	// the worker allowed the call
+	resultAllowed string = "allowed"
+	// the worker answered with a Centrifugo error
+	resultError string = "error"
+	// the worker answered with a disconnect
+	resultDisconnect string = "disconnect"
+	// the call did not produce a response (worker, transport or codec failure)
+	resultFailed string = "failed"
+)
+
+// errorResponse is implemented by every typed Centrifugo proxy response
+type errorResponse interface {
+	GetError() *centrifugov1.Error
+}
+
+// disconnectResponse is implemented by the Centrifugo proxy responses that may disconnect the client
+type disconnectResponse interface {
+	GetDisconnect() *centrifugov1.Disconnect
+}
+
+// Request is a single proxy call on its way through the middleware chain to the worker pool.
+type Request struct {
+	// ID is a unique identifier of the proxy call.
+	ID string
+	// Type is the proxy type, e.g. "connect", "subscribe" or "rpc".
+	Type string
+	// Message is the typed Centrifugo request, e.g. *proxyv1.ConnectRequest.
+	Message proto.Message
+	// Meta is the incoming gRPC metadata, forwarded to the worker in the payload context.
+	Meta metadata.MD
+	// ReceivedAt is the time the plugin received the call.
+	ReceivedAt time.Time
+	// WorkerPID is the PID of the worker which answered the call, set once the worker pool handler
+	// returns. The pool does not expose it, so it is an opt-in contract: the worker has to report it
+	// in the response context, e.g. {"pid":1234}, which the worker SDK does not do by itself.
+	// It is 0 if the worker did not report it or the call failed.
+	WorkerPID int64
+
+	// response is an empty value of the typed Centrifugo response for this request
+	response proto.Message
+	// fallback is set once the call is answered by the plugin without a worker, see fallback
+	fallback bool
+}
+
+// NewResponse returns an empty typed Centrifugo response for the request,
+// e.g. *proxyv1.ConnectResponse for a connect request.
+// Middleware that short-circuits the call should fill and return it.
+func (r *Request) NewResponse() proto.Message {
+	return r.response.ProtoReflect().New().Interface()
+}
+
+// Handler serves a proxy request and returns the typed Centrifugo response.
+type Handler func(ctx context.Context, req *Request) (proto.Message, error)
+
+// Middleware wraps the proxy dispatch. It may mutate the request before calling next,
+// mutate the response after next returns, or return without calling next at all to
+// short-circuit the call (the worker is not touched in that case).
+type Middleware interface {
+	// Middleware wraps the next handler in the chain.
+	Middleware(next Handler) Handler
+	// Name is the middleware name used in the `middleware` configuration list.
+	Name() string
+}
+
+// builtinMiddleware returns the built-in middleware enabled by the configuration, keyed by name.
+func builtinMiddleware(cfg *Config, metrics *proxyMetrics, log *slog.Logger) map[string]Middleware {
+	mdwr := make(map[string]Middleware)
+	mdwr[metrics.Name()] = metrics
+
+	if cfg.AccessLog != nil {
+		mdwr[accessLogMiddleware] = newAccessLog(log, cfg.AccessLog.Fields, newRedactor(cfg.Redact))
+	}
+
+	if cfg.Auth != nil {
+		mdwr[authMiddleware] = newAuth(cfg.Auth)
+	}
+
+	if cfg.ACL != nil {
+		mdwr[aclMiddleware] = newACL(cfg.ACL)
+	}
+
+	return mdwr
+}
+
+// chain wraps h with the named middleware, the first name being the outermost one.
+func chain(h Handler, names []string, mdwr map[string]Middleware) (Handler, error) {
+	const op = errors.Op("centrifuge_middleware_chain")
+
+	for i := len(names) - 1; i >= 0; i-- {
+		m, ok := mdwr[names[i]]
+		if !ok {
+			return nil, errors.E(op, errors.Errorf("middleware '%s' is not registered", names[i]))
+		}
+
+		h = m.Middleware(h)
+	}
+
+	return h, nil
+}
+
+// outcome classifies the result of a proxy call.
+func outcome(resp proto.Message, err error) string {
+	if err != nil || resp == nil {
+		return resultFailed
+	}
+
+	if r, ok := resp.(disconnectResponse); ok && r.GetDisconnect() != nil {
+		return resultDisconnect
+	}
+
+	if r, ok := resp.(errorResponse); ok && r.GetError() != nil {
+		return resultError
+	}
+
+	return resultAllowed
+}
@@ -0,0 +1,171 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type funcMiddleware struct {
+	name string
+	fn   func(next Handler) Handler
+}
+
+func (f *funcMiddleware) Name() string                    { return f.name }
+func (f *funcMiddleware) Middleware(next Handler) Handler { return f.fn(next) }
+
+// orderMiddleware records its name before and after calling next.
+func orderMiddleware(name string, trace *[]string) *funcMiddleware {
+	return &funcMiddleware{name: name, fn: func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (proto.Message, error) {
+			*trace = append(*trace, name+":pre")
+			resp, err := next(ctx, req)
+			*trace = append(*trace, name+":post")
+
+			return resp, err
+		}
+	}}
+}
+
+func TestMiddlewareChainOrder(t *testing.T) {
+	var trace []string
+
+	mdwr := map[string]Middleware{
+		"a": orderMiddleware("a", &trace),
+		"b": orderMiddleware("b", &trace),
+	}
+
+	h, err := chain(func(_ context.Context, req *Request) (proto.Message, error) {
+		trace = append(trace, "exec")
+		return req.NewResponse(), nil
+	}, []string{"a", "b"}, mdwr)
+	require.NoError(t, err)
+
+	_, err = h(t.Context(), &Request{Type: connectType, response: &centrifugov1.ConnectResponse{}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a:pre", "b:pre", "exec", "b:post", "a:post"}, trace)
+}
+
+func TestMiddlewareChainUnknown(t *testing.T) {
+	_, err := chain(nil, []string{"absent"}, map[string]Middleware{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "middleware 'absent' is not registered")
+}
+
+// TestMiddlewareShortCircuit answers the call from the middleware; the pool
+// always fails, so a success here means the worker was never touched.
+func TestMiddlewareShortCircuit(t *testing.T) {
+	p := newTestProxy()
+
+	deny := &funcMiddleware{name: "deny", fn: func(_ Handler) Handler {
+		return func(_ context.Context, req *Request) (proto.Message, error) {
+			resp := req.NewResponse().(*centrifugov1.ConnectResponse)
+			resp.Error = &centrifugov1.Error{Code: 403, Message: "forbidden"}
+
+			return resp, nil
+		}
+	}}
+	require.NoError(t, p.use([]string{"deny"}, map[string]Middleware{"deny": deny}))
+
+	resp, err := p.Connect(t.Context(), &centrifugov1.ConnectRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(403), resp.GetError().GetCode())
+}
+
+func TestMiddlewareMutation(t *testing.T) {
+	p := newProxy(testLogger(), newPoolMuWrapper(&fakePool{}, &sync.RWMutex{}), newRedactor(nil))
+	// replace the worker with an echo of the (mutated) request channel
+	p.handler = func(_ context.Context, req *Request) (proto.Message, error) {
+		return &centrifugov1.SubscribeResponse{Result: &centrifugov1.SubscribeResult{
+			B64Data: req.Message.(*centrifugov1.SubscribeRequest).GetChannel(),
+		}}, nil
+	}
+
+	rewrite := &funcMiddleware{name: "rewrite", fn: func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (proto.Message, error) {
+			req.Message.(*centrifugov1.SubscribeRequest).Channel = "ns:" + req.Message.(*centrifugov1.SubscribeRequest).GetChannel()
+
+			resp, err := next(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			resp.(*centrifugov1.SubscribeResponse).Result.ExpireAt = 42
+
+			return resp, nil
+		}
+	}}
+	require.NoError(t, p.use([]string{"rewrite"}, map[string]Middleware{"rewrite": rewrite}))
+
+	resp, err := p.Subscribe(t.Context(), &centrifugov1.SubscribeRequest{Channel: "chat"})
+	require.NoError(t, err)
+	assert.Equal(t, "ns:chat", resp.GetResult().GetB64Data())
+	assert.Equal(t, int64(42), resp.GetResult().GetExpireAt())
+}
+
+func TestMiddlewareWrongResponseType(t *testing.T) {
+	p := newTestProxy()
+	p.handler = func(_ context.Context, _ *Request) (proto.Message, error) {
+		return &centrifugov1.RefreshResponse{}, nil
+	}
+
+	_, err := p.Connect(t.Context(), &centrifugov1.ConnectRequest{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected connect proxy response type")
+}
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, resultFailed, outcome(nil, errors.New("boom")))
+	assert.Equal(t, resultFailed, outcome(nil, nil))
+	assert.Equal(t, resultAllowed, outcome(&centrifugov1.ConnectResponse{}, nil))
+	assert.Equal(t, resultError, outcome(&centrifugov1.ConnectResponse{Error: &centrifugov1.Error{Code: 1}}, nil))
+	assert.Equal(t, resultDisconnect, outcome(&centrifugov1.ConnectResponse{Disconnect: &centrifugov1.Disconnect{Code: 4000}}, nil))
+	assert.Equal(t, resultError, outcome(&centrifugov1.NotifyCacheEmptyResponse{Error: &centrifugov1.Error{Code: 1}}, nil))
+}
+
+func TestBuiltinMiddlewareOrder(t *testing.T) {
+	cfg := &Config{
+		Auth: &Auth{Metadata: "authorization", Tokens: []string{"t1"}},
+		ACL:  &ACL{Subscribe: []string{"chat:*"}},
+	}
+
+	mdwr := builtinMiddleware(cfg, newProxyMetrics(), testLogger())
+	assert.Contains(t, mdwr, metricsMiddleware)
+	assert.NotContains(t, mdwr, accessLogMiddleware)
+
+	exec := func(_ context.Context, req *Request) (proto.Message, error) {
+		return req.NewResponse(), nil
+	}
+	// no credentials and a forbidden channel, the outer middleware answers
+	req := func() *Request {
+		return &Request{Type: subscribeType, Message: &centrifugov1.SubscribeRequest{Channel: "news"}, response: &centrifugov1.SubscribeResponse{}}
+	}
+
+	h, err := chain(exec, []string{metricsMiddleware, authMiddleware, aclMiddleware}, mdwr)
+	require.NoError(t, err)
+	_, err = h(t.Context(), req())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	h, err = chain(exec, []string{aclMiddleware, authMiddleware, metricsMiddleware}, mdwr)
+	require.NoError(t, err)
+	resp, err := h(t.Context(), req())
+	require.NoError(t, err)
+	assert.Equal(t, permissionDeniedCode, resp.(*centrifugov1.SubscribeResponse).GetError().GetCode())
+
+	// the access log is registered once configured
+	cfg.AccessLog = &AccessLog{Fields: accessLogFields()}
+	mdwr = builtinMiddleware(cfg, newProxyMetrics(), testLogger())
+	h, err = chain(exec, []string{authMiddleware, accessLogMiddleware}, mdwr)
+	require.NoError(t, err)
+	_, err = h(t.Context(), req())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
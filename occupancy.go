@@ -0,0 +1,126 @@
+package centrifuge
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sync"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// occupancy is the registry of the occupied channels, built from the channel state events sent by Centrifugo.
+// The registry is updated before the call reaches the workers and regardless of their answer. It starts empty,
+// the channels occupied before the plugin started are unknown until Centrifugo reports them again.
+type occupancy struct {
+	mu sync.RWMutex
+	// occupied channels and the time they were occupied at, ms
+	channels map[string]int64
+	metrics  *proxyMetrics
+}
+
+func newOccupancy(metrics *proxyMetrics) *occupancy {
+	return &occupancy{
+		channels: make(map[string]int64),
+		metrics:  metrics,
+	}
+}
+
+func (o *occupancy) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		if m, ok := req.Message.(*centrifugov1.NotifyChannelStateRequest); ok {
+			o.update(m.GetEvents())
+		}
+
+		return next(ctx, req)
+	}
+}
+
+func (o *occupancy) update(evs []*centrifugov1.ChannelEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, ev := range evs {
+		switch ev.GetType() {
+		case channelOccupied:
+			o.channels[ev.GetChannel()] = ev.GetTimeMs()
+		case channelVacated:
+			// a vacated event delivered after a newer occupied one is stale
+			if since, ok := o.channels[ev.GetChannel()]; ok && since <= ev.GetTimeMs() {
+				delete(o.channels, ev.GetChannel())
+			}
+		}
+	}
+
+	if o.metrics != nil {
+		o.metrics.occupiedChannels.Set(float64(len(o.channels)))
+	}
+}
+
+// list returns the sorted occupied channels matching the pattern, all of them if the pattern is empty.
+func (o *occupancy) list(pattern string) ([]string, error) {
+	if pattern != "" {
+		// reject the malformed pattern even if there is nothing to match
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Errorf("invalid channel pattern %q: %v", pattern, err)
+		}
+	}
+
+	o.mu.RLock()
+	channels := make([]string, 0, len(o.channels))
+	for ch := range o.channels {
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, ch); !ok {
+				continue
+			}
+		}
+
+		channels = append(channels, ch)
+	}
+	o.mu.RUnlock()
+
+	slices.Sort(channels)
+
+	return channels, nil
+}
+
+// occupied returns the time the channel was occupied at, false if it is not occupied.
+func (o *occupancy) occupied(channel string) (int64, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	since, ok := o.channels[channel]
+
+	return since, ok
+}
+
+// OccupancyRequest selects the occupied channels. The pattern uses the shell glob syntax,
+// e.g. `chat:*`, an empty pattern selects every channel.
+type OccupancyRequest struct {
+	Pattern string `json:"pattern"`
+}
+
+// OccupiedChannels is the list of the occupied channels.
+type OccupiedChannels struct {
+	Channels []string `json:"channels"`
+}
+
+// OccupiedChannelsCount is the number of the occupied channels.
+type OccupiedChannelsCount struct {
+	Count int `json:"count"`
+}
+
+// ChannelOccupancyRequest checks a single channel.
+type ChannelOccupancyRequest struct {
+	Channel string `json:"channel"`
+}
+
+// ChannelOccupancy is the occupancy of a single channel.
+type ChannelOccupancy struct {
+	Channel  string `json:"channel"`
+	Occupied bool   `json:"occupied"`
+	// SinceMs is the time the channel was occupied at as reported by Centrifugo, 0 if not occupied.
+	SinceMs int64 `json:"since_ms"`
+}
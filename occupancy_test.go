@@ -0,0 +1,93 @@
+package centrifuge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func channelStateRequest(evs ...*centrifugov1.ChannelEvent) *Request {
+	return &Request{
+		Type:     notifyChannelStateType,
+		Message:  &centrifugov1.NotifyChannelStateRequest{Events: evs},
+		response: &centrifugov1.NotifyChannelStateResponse{},
+	}
+}
+
+func TestOccupancy(t *testing.T) {
+	m := newProxyMetrics()
+	o := newOccupancy(m)
+
+	h := o.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		return req.NewResponse(), nil
+	})
+
+	_, err := h(t.Context(), channelStateRequest(
+		&centrifugov1.ChannelEvent{Channel: "chat:1", Type: channelOccupied, TimeMs: 10},
+		&centrifugov1.ChannelEvent{Channel: "chat:2", Type: channelOccupied, TimeMs: 11},
+		&centrifugov1.ChannelEvent{Channel: "news", Type: channelOccupied, TimeMs: 12},
+	))
+	require.NoError(t, err)
+
+	channels, err := o.list("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chat:1", "chat:2", "news"}, channels)
+	assert.InDelta(t, 3, testutil.ToFloat64(m.occupiedChannels), 0)
+
+	channels, err = o.list("chat:*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chat:1", "chat:2"}, channels)
+
+	_, err = o.list("chat:[")
+	require.Error(t, err)
+
+	since, ok := o.occupied("news")
+	assert.True(t, ok)
+	assert.Equal(t, int64(12), since)
+
+	_, err = h(t.Context(), channelStateRequest(
+		&centrifugov1.ChannelEvent{Channel: "news", Type: channelVacated, TimeMs: 20},
+		// a stale vacated event of a channel occupied again later
+		&centrifugov1.ChannelEvent{Channel: "chat:1", Type: channelVacated, TimeMs: 5},
+	))
+	require.NoError(t, err)
+
+	_, ok = o.occupied("news")
+	assert.False(t, ok)
+	_, ok = o.occupied("chat:1")
+	assert.True(t, ok)
+	assert.InDelta(t, 2, testutil.ToFloat64(m.occupiedChannels), 0)
+}
+
+func TestOccupancyRPC(t *testing.T) {
+	p := &Plugin{occupancy: newOccupancy(nil)}
+	r := &rpc{plugin: p, log: testLogger()}
+
+	p.occupancy.update([]*centrifugov1.ChannelEvent{
+		{Channel: "chat:1", Type: channelOccupied, TimeMs: 10},
+		{Channel: "news", Type: channelOccupied, TimeMs: 12},
+	})
+
+	list := &OccupiedChannels{}
+	require.NoError(t, r.OccupiedChannels(&OccupancyRequest{Pattern: "chat:*"}, list))
+	assert.Equal(t, []string{"chat:1"}, list.Channels)
+
+	count := &OccupiedChannelsCount{}
+	require.NoError(t, r.OccupiedChannelsCount(&OccupancyRequest{}, count))
+	assert.Equal(t, 2, count.Count)
+
+	occ := &ChannelOccupancy{}
+	require.NoError(t, r.ChannelOccupancy(&ChannelOccupancyRequest{Channel: "news"}, occ))
+	assert.Equal(t, &ChannelOccupancy{Channel: "news", Occupied: true, SinceMs: 12}, occ)
+
+	occ = &ChannelOccupancy{}
+	require.NoError(t, r.ChannelOccupancy(&ChannelOccupancyRequest{Channel: "sport"}, occ))
+	assert.False(t, occ.Occupied)
+
+	require.Error(t, r.ChannelOccupancy(&ChannelOccupancyRequest{}, &ChannelOccupancy{}))
+}
@@ -0,0 +1,394 @@
+package centrifuge
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// extension of the persisted outbox commands
+const outboxFileExt string = ".cmd"
+
+// outbox drop reasons
+const (
+	// the queue was full
+	dropFull string = "full"
+	// the delivery attempts were exhausted
+	dropAttempts string = "attempts"
+	// Centrifugo rejected the command with a permanent error
+	dropRejected string = "rejected"
+)
+
+// sendFunc sends a publish or broadcast command to the Centrifugo server API. The transport
+// errors are returned as err, the Centrifugo errors as apiErr.
+type sendFunc func(ctx context.Context, cmd *v1Client.Command) (apiErr *v1Client.Error, err error)
+
+type outboxItem struct {
+	// file name of the persisted command, empty if the outbox is not persisted
+	file     string
+	cmd      *v1Client.Command
+	queuedAt time.Time
+}
+
+// outbox is the asynchronous publish queue. The commands are sent one by one in the order they
+// were queued, so the publications of a channel are never reordered. A failed command is retried
+// with backoff before the next one is sent, up to the max attempts, the commands failing with a
+// permanent error are dropped at once, so a single command does not hold the queue.
+type outbox struct {
+	mu      sync.Mutex
+	log     *slog.Logger
+	metrics *proxyMetrics
+	cfg     *Outbox
+	send    sendFunc
+
+	items []*outboxItem
+	seq   uint64
+	// set by close, the new commands are rejected
+	stopped bool
+	// signals the dispatcher about a new item
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newOutbox(cfg *Outbox, send sendFunc, metrics *proxyMetrics, log *slog.Logger) (*outbox, error) {
+	const op = errors.Op("centrifuge_outbox")
+
+	o := &outbox{
+		log:     log,
+		metrics: metrics,
+		cfg:     cfg,
+		send:    send,
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if cfg.Dir == "" {
+		return o, nil
+	}
+
+	err := os.MkdirAll(cfg.Dir, 0o750)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = o.load()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return o, nil
+}
+
+// load queues the persisted commands in the order they were written.
+func (o *outbox) load() error {
+	entries, err := os.ReadDir(o.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	// zero padded sequence numbers, the names sort in the queue order
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), outboxFileExt) {
+			continue
+		}
+
+		file := filepath.Join(o.cfg.Dir, e.Name())
+
+		data, errR := os.ReadFile(file)
+		if errR != nil {
+			o.log.Warn("failed to read the outbox command", "file", e.Name(), "error", errR)
+			continue
+		}
+
+		cmd := &v1Client.Command{}
+		errR = proto.Unmarshal(data, cmd)
+		if errR != nil || (cmd.GetPublish() == nil && cmd.GetBroadcast() == nil) {
+			o.log.Warn("removing a malformed outbox command", "file", e.Name(), "error", errR)
+			_ = os.Remove(file)
+
+			continue
+		}
+
+		queuedAt := time.Now()
+		if info, errI := e.Info(); errI == nil {
+			queuedAt = info.ModTime()
+		}
+
+		var seq uint64
+		_, _ = fmt.Sscanf(e.Name(), "%d", &seq)
+		o.seq = max(o.seq, seq)
+
+		o.items = append(o.items, &outboxItem{file: file, cmd: cmd, queuedAt: queuedAt})
+	}
+
+	if len(o.items) > 0 {
+		o.log.Info("outbox commands loaded", "count", len(o.items))
+	}
+
+	o.observe()
+
+	return nil
+}
+
+// enqueue queues the command, it fails when the queue is full or the command could not be persisted.
+func (o *outbox) enqueue(cmd *v1Client.Command) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stopped {
+		return 0, errors.Str("centrifugo client is stopped")
+	}
+
+	if len(o.items) >= o.cfg.Size {
+		if o.metrics != nil {
+			o.metrics.outboxDropped.WithLabelValues(dropFull).Inc()
+		}
+
+		return 0, errors.Errorf("outbox is full, %d commands queued", len(o.items))
+	}
+
+	o.seq++
+	it := &outboxItem{cmd: cmd, queuedAt: time.Now()}
+
+	if o.cfg.Dir != "" {
+		it.file = filepath.Join(o.cfg.Dir, fmt.Sprintf("%020d%s", o.seq, outboxFileExt))
+
+		err := persist(o.cfg.Dir, it.file, cmd)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	o.items = append(o.items, it)
+	o.observeLocked()
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return len(o.items), nil
+}
+
+// head returns the oldest queued command, nil if the queue is empty.
+func (o *outbox) head() *outboxItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.items) == 0 {
+		return nil
+	}
+
+	return o.items[0]
+}
+
+// remove drops the delivered or discarded head of the queue.
+func (o *outbox) remove(it *outboxItem) {
+	o.mu.Lock()
+	o.items = slices.DeleteFunc(o.items, func(i *outboxItem) bool { return i == it })
+	o.observeLocked()
+	o.mu.Unlock()
+
+	if it.file != "" {
+		err := os.Remove(it.file)
+		if err != nil {
+			o.log.Warn("failed to remove the outbox command", "file", it.file, "error", err)
+		}
+	}
+}
+
+func (o *outbox) depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.items)
+}
+
+func (o *outbox) observe() {
+	o.mu.Lock()
+	o.observeLocked()
+	o.mu.Unlock()
+}
+
+// observeLocked updates the depth and age gauges, must be called with the lock held.
+func (o *outbox) observeLocked() {
+	if o.metrics == nil {
+		return
+	}
+
+	o.metrics.outboxDepth.Set(float64(len(o.items)))
+
+	age := 0.0
+	if len(o.items) > 0 {
+		age = time.Since(o.items[0].queuedAt).Seconds()
+	}
+
+	o.metrics.outboxAge.Set(age)
+}
+
+// dispatch sends the queued commands until the outbox is stopped.
+func (o *outbox) dispatch() {
+	defer close(o.done)
+
+	for {
+		it := o.head()
+		if it == nil {
+			select {
+			case <-o.stop:
+				return
+			case <-o.notify:
+				continue
+			}
+		}
+
+		if !o.deliver(it) {
+			return
+		}
+
+		o.remove(it)
+	}
+}
+
+// deliver sends the command until it is accepted, rejected or the attempts are exhausted.
+// It returns false when the outbox was stopped, the command stays queued then.
+func (o *outbox) deliver(it *outboxItem) bool {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = o.cfg.InitialBackoff
+	b.MaxInterval = o.cfg.MaxBackoff
+	b.MaxElapsedTime = 0
+	b.Reset()
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), o.cfg.Timeout)
+		apiErr, err := o.send(ctx, it.cmd)
+		cancel()
+
+		switch {
+		case err == nil && apiErr == nil:
+			return true
+		case err == nil && !apiErr.GetTemporary():
+			o.drop(it, dropRejected, "code", apiErr.GetCode(), "message", apiErr.GetMessage())
+			return true
+		case err != nil && permanentError(err):
+			// the command fails the same way on every attempt, e.g. it is over the message size limit
+			o.drop(it, dropRejected, "error", err.Error())
+			return true
+		case o.cfg.MaxAttempts > 0 && attempt >= o.cfg.MaxAttempts:
+			o.drop(it, dropAttempts, "attempts", attempt, "error", deliveryError(apiErr, err))
+			return true
+		}
+
+		wait := b.NextBackOff()
+		o.log.Warn("outbox delivery failed, retrying", "attempt", attempt, "retry_in", wait, "error", deliveryError(apiErr, err))
+		o.observe()
+
+		select {
+		case <-o.stop:
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (o *outbox) drop(it *outboxItem, reason string, args ...any) {
+	if o.metrics != nil {
+		o.metrics.outboxDropped.WithLabelValues(reason).Inc()
+	}
+
+	o.log.Error("outbox command dropped", append([]any{"reason", reason, "channels", commandChannels(it.cmd)}, args...)...)
+}
+
+// close stops the dispatcher, the undelivered commands are kept on disk if the outbox is persisted.
+func (o *outbox) close() {
+	o.mu.Lock()
+	o.stopped = true
+	o.mu.Unlock()
+
+	close(o.stop)
+	<-o.done
+
+	if n := o.depth(); n > 0 {
+		if o.cfg.Dir == "" {
+			o.log.Warn("outbox stopped, the queued commands are lost", "count", n)
+			return
+		}
+
+		o.log.Info("outbox stopped, the queued commands are kept on disk", "count", n)
+	}
+}
+
+// permanentError reports whether the transport error is caused by the command itself,
+// so repeating the command can not succeed.
+func permanentError(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.ResourceExhausted, codes.Unimplemented, codes.OutOfRange:
+		return true
+	default:
+		return false
+	}
+}
+
+func deliveryError(apiErr *v1Client.Error, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("centrifugo error %d: %s", apiErr.GetCode(), apiErr.GetMessage())
+}
+
+func commandChannels(cmd *v1Client.Command) []string {
+	if cmd.GetPublish() != nil {
+		return []string{cmd.GetPublish().GetChannel()}
+	}
+
+	return cmd.GetBroadcast().GetChannels()
+}
+
+// persist writes the message to a temporary file in dir and renames it to file,
+// so a crash never leaves a partial file behind.
+func persist(dir, file string, m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errC := tmp.Close(); err == nil {
+		err = errC
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// AsyncResponse is the answer of the asynchronous publish and broadcast RPC methods.
+type AsyncResponse struct {
+	// Depth is the number of the queued commands including this one.
+	Depth int `json:"depth"`
+}
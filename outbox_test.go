@@ -0,0 +1,235 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeAPI answers the outbox commands with the queued results, then accepts everything
+type fakeAPI struct {
+	mu       sync.Mutex
+	results  []error
+	apiErrs  []*v1Client.Error
+	attempts int
+	sent     []string
+}
+
+func (f *fakeAPI) send(_ context.Context, cmd *v1Client.Command) (*v1Client.Error, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.attempts++
+
+	if len(f.results) > 0 {
+		err := f.results[0]
+		f.results = f.results[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(f.apiErrs) > 0 {
+		apiErr := f.apiErrs[0]
+		f.apiErrs = f.apiErrs[1:]
+		if apiErr != nil {
+			return apiErr, nil
+		}
+	}
+
+	f.sent = append(f.sent, string(cmd.GetPublish().GetData())+string(cmd.GetBroadcast().GetData()))
+
+	return nil, nil
+}
+
+func (f *fakeAPI) delivered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.sent...)
+}
+
+func testOutboxConfig() *Outbox {
+	return &Outbox{Size: 10, Timeout: time.Second, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 5}
+}
+
+func publishCommand(channel, data string) *v1Client.Command {
+	return &v1Client.Command{Publish: &v1Client.PublishRequest{Channel: channel, Data: []byte(data)}}
+}
+
+func TestOutboxRetriesInOrder(t *testing.T) {
+	api := &fakeAPI{results: []error{errors.New("unavailable"), errors.New("unavailable")}}
+	m := newProxyMetrics()
+
+	o, err := newOutbox(testOutboxConfig(), api.send, m, testLogger())
+	require.NoError(t, err)
+
+	for _, d := range []string{"1", "2", "3"} {
+		_, err = o.enqueue(publishCommand("news", d))
+		require.NoError(t, err)
+	}
+	_, err = o.enqueue(&v1Client.Command{Broadcast: &v1Client.BroadcastRequest{Channels: []string{"news", "chat"}, Data: []byte("4")}})
+	require.NoError(t, err)
+	assert.InDelta(t, 4, testutil.ToFloat64(m.outboxDepth), 0)
+
+	go o.dispatch()
+	t.Cleanup(o.close)
+
+	require.Eventually(t, func() bool { return len(api.delivered()) == 4 }, time.Second, time.Millisecond*5)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, api.delivered())
+	assert.Equal(t, 6, api.attempts)
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(m.outboxDepth) == 0 }, time.Second, time.Millisecond*5)
+}
+
+func TestOutboxDrops(t *testing.T) {
+	cfg := testOutboxConfig()
+	cfg.Size = 2
+	cfg.MaxAttempts = 2
+
+	api := &fakeAPI{
+		// the first command exhausts the attempts
+		results: []error{errors.New("unavailable"), errors.New("unavailable")},
+		// the second one is rejected
+		apiErrs: []*v1Client.Error{{Code: 102, Message: "unknown channel"}},
+	}
+	m := newProxyMetrics()
+
+	o, err := newOutbox(cfg, api.send, m, testLogger())
+	require.NoError(t, err)
+
+	_, err = o.enqueue(publishCommand("news", "1"))
+	require.NoError(t, err)
+	_, err = o.enqueue(publishCommand("news", "2"))
+	require.NoError(t, err)
+	_, err = o.enqueue(publishCommand("news", "3"))
+	require.Error(t, err)
+
+	go o.dispatch()
+	t.Cleanup(o.close)
+
+	require.Eventually(t, func() bool { return o.depth() == 0 }, time.Second, time.Millisecond*5)
+	assert.Empty(t, api.delivered())
+	assert.InDelta(t, 1, testutil.ToFloat64(m.outboxDropped.WithLabelValues(dropFull)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.outboxDropped.WithLabelValues(dropAttempts)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.outboxDropped.WithLabelValues(dropRejected)), 0)
+}
+
+func TestOutboxTemporaryError(t *testing.T) {
+	api := &fakeAPI{apiErrs: []*v1Client.Error{{Code: 100, Message: "internal", Temporary: true}}}
+
+	o, err := newOutbox(testOutboxConfig(), api.send, nil, testLogger())
+	require.NoError(t, err)
+
+	_, err = o.enqueue(publishCommand("news", "1"))
+	require.NoError(t, err)
+
+	go o.dispatch()
+	t.Cleanup(o.close)
+
+	require.Eventually(t, func() bool { return len(api.delivered()) == 1 }, time.Second, time.Millisecond*5)
+	assert.Equal(t, 2, api.attempts)
+}
+
+func TestOutboxPersistence(t *testing.T) {
+	cfg := testOutboxConfig()
+	cfg.Dir = t.TempDir()
+
+	// Centrifugo is down, the commands stay on disk
+	o, err := newOutbox(cfg, func(context.Context, *v1Client.Command) (*v1Client.Error, error) {
+		return nil, errors.New("unavailable")
+	}, nil, testLogger())
+	require.NoError(t, err)
+
+	for _, d := range []string{"1", "2", "3"} {
+		_, err = o.enqueue(publishCommand("news", d))
+		require.NoError(t, err)
+	}
+
+	go o.dispatch()
+	o.close()
+
+	// the dispatcher is gone, the command would not be sent until the next start
+	_, err = o.enqueue(publishCommand("news", "5"))
+	require.EqualError(t, err, "centrifugo client is stopped")
+
+	entries, err := os.ReadDir(cfg.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	api := &fakeAPI{}
+	o, err = newOutbox(cfg, api.send, nil, testLogger())
+	require.NoError(t, err)
+	assert.Equal(t, 3, o.depth())
+
+	// new commands are queued after the loaded ones
+	_, err = o.enqueue(publishCommand("news", "4"))
+	require.NoError(t, err)
+
+	go o.dispatch()
+	t.Cleanup(o.close)
+
+	require.Eventually(t, func() bool { return len(api.delivered()) == 4 }, time.Second, time.Millisecond*5)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, api.delivered())
+
+	require.Eventually(t, func() bool {
+		entries, err = os.ReadDir(cfg.Dir)
+		return err == nil && len(entries) == 0
+	}, time.Second, time.Millisecond*5)
+}
+
+func TestAsyncPublishRPC(t *testing.T) {
+	r := &rpc{plugin: &Plugin{}, log: testLogger()}
+	require.Error(t, r.AsyncPublish(&v1Client.PublishRequest{Channel: "news"}, &AsyncResponse{}))
+
+	o, err := newOutbox(testOutboxConfig(), (&fakeAPI{}).send, nil, testLogger())
+	require.NoError(t, err)
+	r.plugin.outbox = o
+
+	out := &AsyncResponse{}
+	require.NoError(t, r.AsyncPublish(&v1Client.PublishRequest{Channel: "news"}, out))
+	assert.Equal(t, 1, out.Depth)
+
+	require.NoError(t, r.AsyncBroadcast(&v1Client.BroadcastRequest{Channels: []string{"news"}}, out))
+	assert.Equal(t, 2, out.Depth)
+
+	require.Error(t, r.AsyncPublish(&v1Client.PublishRequest{}, out))
+	require.Error(t, r.AsyncBroadcast(&v1Client.BroadcastRequest{}, out))
+}
+
+func TestOutboxPermanentError(t *testing.T) {
+	cfg := testOutboxConfig()
+	cfg.MaxAttempts = -1
+
+	api := &fakeAPI{results: []error{status.Error(codes.ResourceExhausted, "message larger than max")}}
+	m := newProxyMetrics()
+
+	o, err := newOutbox(cfg, api.send, m, testLogger())
+	require.NoError(t, err)
+
+	_, err = o.enqueue(publishCommand("news", "1"))
+	require.NoError(t, err)
+	_, err = o.enqueue(publishCommand("chat", "2"))
+	require.NoError(t, err)
+
+	go o.dispatch()
+	t.Cleanup(o.close)
+
+	// the oversized command does not hold the queue
+	require.Eventually(t, func() bool { return len(api.delivered()) == 1 }, time.Second, time.Millisecond*5)
+	assert.Equal(t, []string{"2"}, api.delivered())
+	assert.Equal(t, 2, api.attempts)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.outboxDropped.WithLabelValues(dropRejected)), 0)
+
+	assert.False(t, permanentError(status.Error(codes.Unavailable, "connection refused")))
+	assert.False(t, permanentError(errors.New("connection reset")))
+}
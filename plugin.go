@@ -4,11 +4,17 @@ import (
 	"context"
 	stderr "errors"
 	"log/slog"
+	"net"
+	"net/http"
+	"slices"
 	"sync"
 	"time"
 
+	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
 	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/roadrunner-server/endure/v2/dep"
 	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/events"
 	"github.com/roadrunner-server/pool/v2/payload"
 	"github.com/roadrunner-server/pool/v2/pool"
 	staticPool "github.com/roadrunner-server/pool/v2/pool/static_pool"
@@ -16,6 +22,9 @@ import (
 	"github.com/roadrunner-server/pool/v2/worker"
 	"github.com/roadrunner-server/tcplisten"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 const (
@@ -65,10 +74,47 @@ type Plugin struct {
 
 	// proxy server
 	gRPCServer    *grpc.Server
+	httpServer    *http.Server
+	health        *health.Server
+	healthStop    chan struct{}
 	client        *client
 	statsExporter *StatsExporter
+	proxyMetrics  *proxyMetrics
+	events        events.EventBus
+
+	// healthMu serializes the health status updates with the resets
+	healthMu  sync.Mutex
+	resetting bool
+	// number of the resets started, an update read before a reset is discarded
+	resets uint64
+
+	// proxy middleware, built-in and collected from other plugins
+	mdwr map[string]Middleware
+	// proxy is nil until Serve
+	proxy    *Proxy
+	recorder *recorder
+	shadow   *shadow
+	canary   *canary
+	enabled  *enabledProxies
+	// occupied channels, filled from the channel state events
+	occupancy *occupancy
+	// nil unless the cache recovery is configured
+	cache *cacheRecovery
+	// nil unless the outbox is configured
+	outbox *outbox
+	// nil unless the idempotent publishing is configured
+	idempotency *idempotency
+	// nil unless the token signing keys are configured
+	tokens *tokens
+	// capabilities of the stable and the canary pool workers, nil unless the handshake is configured
+	stableCaps *workerCapabilities
+	canaryCaps *workerCapabilities
 
 	pool Pool
+	// shadow pool, nil unless the traffic mirroring is configured
+	shadowPool Pool
+	// canary pool, nil unless the canary routing is configured or after the promotion
+	canaryPool Pool
 }
 
 func (p *Plugin) Init(cfg Configurer, log Logger, server Server) error {
@@ -90,9 +136,51 @@ func (p *Plugin) Init(cfg Configurer, log Logger, server Server) error {
 	p.log = log.NamedLogger(name)
 	p.server = server
 	// nosemgrep: go.grpc.security.grpc-server-insecure-connection.grpc-server-insecure-connection
-	p.gRPCServer = grpc.NewServer()
+	p.gRPCServer = grpc.NewServer(p.cfg.GrpcServer.serverOptions()...)
+	p.health = newHealthServer()
+	p.healthStop = make(chan struct{})
+	healthv1.RegisterHealthServer(p.gRPCServer, p.health)
+	if p.cfg.GrpcServer != nil && p.cfg.GrpcServer.Reflection {
+		reflection.Register(p.gRPCServer)
+	}
 	p.client = newClient(p.cfg.GrpcAPIAddress, p.cfg.TLS, p.log, p.cfg.UseCompressor)
+	if p.cfg.Transport == transportHTTP {
+		p.client.httpAPI = p.cfg.HTTPAPI
+	}
+	if p.cfg.Batching != nil {
+		p.client.batcher = newBatcher(p.cfg.Batching, p.client.batch)
+	}
 	p.statsExporter = newWorkersExporter(p)
+	p.proxyMetrics = newProxyMetrics()
+	p.events, _ = events.NewEventBus()
+	p.occupancy = newOccupancy(p.proxyMetrics)
+
+	if p.cfg.CacheRecovery != nil {
+		p.cache, err = newCacheRecovery(p.cfg.CacheRecovery, p.publish, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	if p.cfg.Idempotency != nil {
+		p.idempotency = newIdempotency(p.cfg.Idempotency, p.log)
+	}
+
+	if p.cfg.Outbox != nil {
+		p.outbox, err = newOutbox(p.cfg.Outbox, p.sendCommand, p.proxyMetrics, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	if p.cfg.Tokens != nil {
+		p.tokens, err = newTokens(p.cfg.Tokens)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	p.mdwr = builtinMiddleware(p.cfg, p.proxyMetrics, p.log)
 
 	return nil
 }
@@ -102,6 +190,14 @@ func (p *Plugin) Serve() chan error {
 
 	const op = errors.Op("centrifuge_serve")
 
+	if p.outbox != nil {
+		go p.outbox.dispatch()
+	}
+
+	if p.client.batcher != nil {
+		go p.client.batcher.run()
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -121,23 +217,141 @@ func (p *Plugin) Serve() chan error {
 		return errCh
 	}
 
-	centrifugov1.RegisterCentrifugoProxyServer(p.gRPCServer, &Proxy{
-		log: p.log,
-		pw:  newPoolMuWrapper(p.pool, &p.mu),
-	})
+	listeners := []net.Listener{l}
+	for _, lc := range p.cfg.Listeners {
+		ln, errL := createListener(lc)
+		if errL != nil {
+			for _, cl := range listeners {
+				_ = cl.Close()
+			}
+
+			errCh <- errors.E(op, errL)
 
-	go func() {
-		errL := p.gRPCServer.Serve(l)
+			return errCh
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	red := newRedactor(p.cfg.Redact)
+
+	proxy := newProxy(p.log, newPoolMuWrapper(p.pool, &p.mu), red)
+	proxy.proxies = p.cfg.Proxies
+	proxy.metrics = p.proxyMetrics
+	proxy.contextVersion = p.cfg.ContextVersion
+
+	p.enabled = newEnabledProxies(p.cfg.EnabledProxies, p.cfg.Proxies, p.proxyMetrics)
+	if p.cfg.Handshake != nil {
+		p.stableCaps = newWorkerCapabilities(p.enabled)
+		proxy.handler = p.stableCaps.Middleware(proxy.handler)
+	}
+
+	if p.cfg.Canary != nil {
+		p.canaryPool, err = p.server.NewPool(context.Background(), p.cfg.Canary.Pool, map[string]string{RRMode: RRModeCentrifuge, RRCanary: "true"}, nil)
+		if err != nil {
+			errCh <- errors.E(op, err)
+
+			return errCh
+		}
+
+		cp := newProxy(p.log.With("pool", canaryPool), newPoolMuWrapper(p.canaryPool, &p.mu), red)
+		cp.proxies = p.cfg.Proxies
+		cp.metrics = p.proxyMetrics
+		cp.contextVersion = p.cfg.ContextVersion
+
+		if p.cfg.Handshake != nil {
+			p.canaryCaps = newWorkerCapabilities(p.enabled)
+			cp.handler = p.canaryCaps.Middleware(cp.handler)
+		}
+
+		p.canary = newCanary(p.cfg.Canary, cp.handler, p.proxyMetrics, p.log)
+		proxy.handler = p.canary.Middleware(proxy.handler)
+	}
+
+	proxy.handler = newRetrier(p.cfg.Proxies, p.proxyMetrics, p.log).Middleware(proxy.handler)
+
+	if p.cfg.Shadow != nil {
+		p.shadowPool, err = p.server.NewPool(context.Background(), p.cfg.Shadow.Pool, map[string]string{RRMode: RRModeCentrifuge, RRShadow: "true"}, nil)
+		if err != nil {
+			errCh <- errors.E(op, err)
+
+			return errCh
+		}
+
+		sp := newProxy(p.log.With("pool", "shadow"), newPoolMuWrapper(p.shadowPool, &p.mu), red)
+		sp.contextVersion = p.cfg.ContextVersion
+
+		p.shadow = newShadow(p.cfg.Shadow, sp.exec, p.proxyMetrics, p.log)
+		proxy.handler = p.shadow.Middleware(proxy.handler)
+	}
+
+	if p.cfg.Record != nil {
+		p.recorder, err = newRecorder(p.cfg.Record, red, p.log)
+		if err != nil {
+			errCh <- errors.E(op, err)
+
+			return errCh
+		}
+
+		proxy.handler = p.recorder.Middleware(proxy.handler)
+	}
+
+	proxy.handler = newBreakers(p.cfg.Proxies, p.proxyMetrics, p.log).Middleware(proxy.handler)
+	proxy.handler = newEventEmitter(p.events).Middleware(proxy.handler)
+	proxy.handler = p.enabled.Middleware(proxy.handler)
+	proxy.handler = p.occupancy.Middleware(proxy.handler)
+	if p.cache != nil {
+		proxy.handler = p.cache.Middleware(proxy.handler)
+	}
+	logEnabledProxies(p.log, p.cfg.EnabledProxies)
+
+	err = proxy.use(p.cfg.Middleware, p.mdwr)
+	if err != nil {
+		errCh <- errors.E(op, err)
+
+		return errCh
+	}
+
+	if p.cfg.AccessLog != nil && !slices.Contains(p.cfg.Middleware, accessLogMiddleware) {
+		// not placed by the middleware list, the outermost one
+		proxy.handler = p.mdwr[accessLogMiddleware].Middleware(proxy.handler)
+	}
+
+	p.proxy = proxy
+	centrifugov1.RegisterCentrifugoProxyServer(p.gRPCServer, proxy)
+
+	go p.watchHealth(p.healthStop)
+
+	if p.cfg.Handshake != nil {
+		// the handshake takes the plugin lock held by Serve, it runs once Serve returns
+		go p.handshakes()
+		go p.watchCapabilities(p.healthStop)
+	}
+
+	for _, ln := range listeners {
+		go p.serve(ln)
+	}
+
+	if p.cfg.HTTPProxy != nil {
+		hl, errL := tcplisten.CreateListener(p.cfg.HTTPProxy.Address)
 		if errL != nil {
-			if stderr.Is(errL, grpc.ErrServerStopped) {
-				p.log.Info("grpc proxy stopped")
+			errCh <- errors.E(op, errL)
 
-				return
-			}
+			return errCh
+		}
 
-			p.log.Error("grpc proxy error", "error", errL)
+		p.httpServer = &http.Server{
+			Handler:           newHTTPProxy(proxy, int64(p.cfg.HTTPProxy.MaxRequestSize)*1024*1024, p.log),
+			ReadHeaderTimeout: time.Minute,
 		}
-	}()
+
+		go func() {
+			errS := p.httpServer.Serve(hl)
+			if errS != nil && !stderr.Is(errS, http.ErrServerClosed) {
+				p.log.Error("http proxy error", "error", errS)
+			}
+		}()
+	}
 
 	err = p.client.connect()
 	if err != nil {
@@ -151,12 +365,50 @@ func (p *Plugin) Serve() chan error {
 
 func (p *Plugin) Stop(ctx context.Context) error {
 	stCh := make(chan struct{}, 1)
+	if p.health != nil {
+		close(p.healthStop)
+		// NOT_SERVING from now on, further updates are ignored
+		p.health.Shutdown()
+	}
+
 	go func() {
+		if p.httpServer != nil {
+			err := p.httpServer.Shutdown(ctx)
+			if err != nil {
+				p.log.Error("http proxy shutdown", "error", err)
+			}
+		}
+
+		// no mirror is started from now on, the in-flight ones take the read lock to reach the shadow pool
+		if p.shadow != nil {
+			p.shadow.wait()
+		}
+
+		if p.outbox != nil {
+			p.outbox.close()
+		}
+
+		if p.client != nil && p.client.batcher != nil {
+			p.client.batcher.close()
+		}
+
 		p.mu.Lock()
 		p.gRPCServer.GracefulStop()
 		if p.pool != nil {
 			p.pool.Destroy(ctx)
 		}
+		if p.shadowPool != nil {
+			p.shadowPool.Destroy(ctx)
+		}
+		if p.canaryPool != nil {
+			p.canaryPool.Destroy(ctx)
+		}
+		if p.recorder != nil {
+			err := p.recorder.close()
+			if err != nil {
+				p.log.Error("failed to close the recording", "error", err)
+			}
+		}
 		p.mu.Unlock()
 		stCh <- struct{}{}
 	}()
@@ -208,11 +460,30 @@ func (p *Plugin) Reset() error {
 		return nil
 	}
 
+	p.startReset()
+	defer p.finishReset()
+
 	err := p.pool.Reset(ctxTout)
 	if err != nil {
 		return errors.E(op, err)
 	}
 
+	if p.shadowPool != nil {
+		err = p.shadowPool.Reset(ctxTout)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	if p.canaryPool != nil {
+		err = p.canaryPool.Reset(ctxTout)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	p.handshakes()
+
 	p.log.Info("plugin was successfully reset")
 
 	return nil
@@ -222,13 +493,241 @@ func (p *Plugin) Name() string {
 	return name
 }
 
+// Collects collects proxy middleware provided by other plugins
+func (p *Plugin) Collects() []*dep.In {
+	return []*dep.In{
+		dep.Fits(func(pp any) {
+			mdwr := pp.(Middleware)
+
+			p.mu.Lock()
+			p.mdwr[mdwr.Name()] = mdwr
+			p.mu.Unlock()
+		}, (*Middleware)(nil)),
+	}
+}
+
 func (p *Plugin) RPC() any {
 	return &rpc{
 		client: p.client,
+		plugin: p,
 		log:    p.log,
 	}
 }
 
+// replay feeds the recording at path into the worker pool, bypassing the middleware.
+func (p *Plugin) replay(ctx context.Context, path string) (*ReplayReport, error) {
+	p.mu.RLock()
+	proxy := p.proxy
+	p.mu.RUnlock()
+
+	if proxy == nil {
+		return nil, errors.Str("RoadRunner is not ready yet, try in a few seconds")
+	}
+
+	return replayFile(ctx, path, proxy.exec)
+}
+
+// setCanaryWeight changes the percentage of the proxy calls routed to the canary pool.
+func (p *Plugin) setCanaryWeight(w int) (*CanaryState, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.canary == nil || p.canaryPool == nil {
+		return nil, errors.Str("canary pool is not configured or already promoted")
+	}
+
+	if w < 0 || w > 100 {
+		return nil, errors.Errorf("canary weight should be between 0 and 100, got %d", w)
+	}
+
+	p.canary.setWeight(w)
+	p.log.Info("canary weight changed", "weight", w)
+
+	return p.canary.state(), nil
+}
+
+// promoteCanary replaces the stable pool with the canary pool and destroys the former stable pool.
+func (p *Plugin) promoteCanary(ctx context.Context) (*CanaryState, error) {
+	p.mu.Lock()
+
+	if p.canary == nil || p.canaryPool == nil || p.proxy == nil {
+		p.mu.Unlock()
+		return nil, errors.Str("canary pool is not configured or already promoted")
+	}
+
+	old := p.pool
+	p.pool = p.canaryPool
+	p.proxy.pw.pool = p.canaryPool
+	p.canaryPool = nil
+	p.canary.promote()
+	if p.stableCaps != nil {
+		// the calls routed to the promoted pool are gated by its capabilities until it is asked again
+		p.stableCaps.takeOver(p.canaryCaps)
+	}
+	promoted := p.pool
+
+	p.mu.Unlock()
+
+	p.log.Info("canary pool promoted")
+	old.Destroy(ctx)
+
+	// a legacy pool is not asked again
+	if p.stableCaps != nil && !p.stableCaps.isLegacy() {
+		p.handshake(stablePool, promoted, p.stableCaps)
+	}
+
+	return p.canary.state(), nil
+}
+
+// canaryState returns the canary routing state.
+func (p *Plugin) canaryState() (*CanaryState, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.canary == nil {
+		return nil, errors.Str("canary pool is not configured")
+	}
+
+	return p.canary.state(), nil
+}
+
+// handshake asks a worker of the pool for the capabilities, the calls routed to the pool are gated by
+// them. When the handshake fails, every enabled call is routed to the workers. The pool is reached
+// through the plugin lock, the caller should not hold it.
+func (p *Plugin) handshake(name string, pool Pool, wc *workerCapabilities) {
+	if wc == nil || pool == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Handshake.Timeout)
+	defer cancel()
+
+	wc.setPIDs(p.workerPIDs(pool))
+
+	caps, err := handshake(ctx, newPoolMuWrapper(pool, &p.mu).Exec, p.cfg.ContextVersion)
+	if err != nil {
+		p.log.Warn("worker handshake failed, all enabled proxy types are routed to the workers, the handshake is not repeated until reset", "pool", name, "error", err)
+		wc.setResult(nil)
+
+		return
+	}
+
+	if caps.ProtocolVersion != protocolVersion {
+		p.log.Warn("worker protocol version mismatch", "pool", name, "plugin", protocolVersion, "worker", caps.ProtocolVersion)
+	}
+
+	for _, typ := range caps.Proxies {
+		if !slices.Contains(proxyTypes(), typ) {
+			p.log.Warn("worker declared an unknown proxy type", "pool", name, "type", typ)
+		}
+	}
+
+	p.log.Info("worker capabilities", "pool", name, "protocol_version", caps.ProtocolVersion, "proxies", caps.Proxies, "rpc_methods", caps.RPCMethods)
+	wc.setResult(caps)
+}
+
+// handshakes asks the stable and the canary pools for the capabilities.
+func (p *Plugin) handshakes() {
+	p.mu.RLock()
+	stable, canary := p.pool, p.canaryPool
+	p.mu.RUnlock()
+
+	p.handshake(stablePool, stable, p.stableCaps)
+	p.handshake(canaryPool, canary, p.canaryCaps)
+}
+
+// watchCapabilities repeats the handshake of a pool once its workers changed, e.g. restarted by the
+// supervisor after max_jobs, the TTL or a crash. The workers of a legacy pool, which failed the
+// handshake, are not asked again, an older worker could exit on every handshake.
+func (p *Plugin) watchCapabilities(stop chan struct{}) {
+	ticker := time.NewTicker(p.cfg.Handshake.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.mu.RLock()
+			stable, canary := p.pool, p.canaryPool
+			p.mu.RUnlock()
+
+			if stable != nil && p.stableCaps.setPIDs(p.workerPIDs(stable)) {
+				p.handshake(stablePool, stable, p.stableCaps)
+			}
+
+			if canary != nil && p.canaryCaps != nil && p.canaryCaps.setPIDs(p.workerPIDs(canary)) {
+				p.handshake(canaryPool, canary, p.canaryCaps)
+			}
+		}
+	}
+}
+
+// workerPIDs returns the PIDs of the pool workers.
+func (p *Plugin) workerPIDs(pool Pool) []int64 {
+	p.mu.RLock()
+	workers := pool.Workers()
+	p.mu.RUnlock()
+
+	pids := make([]int64, 0, len(workers))
+	for _, w := range workers {
+		pids = append(pids, w.Pid())
+	}
+
+	return pids
+}
+
+// publish sends the publication to the Centrifugo server API.
+func (p *Plugin) publish(ctx context.Context, req *v1Client.PublishRequest) error {
+	resp, err := p.client.publish(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if resp.GetError() != nil {
+		return errors.Errorf("centrifugo error %d: %s", resp.GetError().GetCode(), resp.GetError().GetMessage())
+	}
+
+	return nil
+}
+
+// sendCommand sends a queued publish or broadcast command to the Centrifugo server API.
+func (p *Plugin) sendCommand(ctx context.Context, cmd *v1Client.Command) (*v1Client.Error, error) {
+	if cmd.GetPublish() != nil {
+		resp, err := p.client.publish(ctx, cmd.GetPublish())
+		if err != nil {
+			return nil, err
+		}
+
+		if p.cache != nil && resp.GetError() == nil {
+			p.cache.store(cmd.GetPublish())
+		}
+
+		return resp.GetError(), nil
+	}
+
+	resp, err := p.client.broadcast(ctx, cmd.GetBroadcast())
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetError(), nil
+}
+
+// serve accepts the proxy connections on l until the gRPC server is stopped.
+func (p *Plugin) serve(l net.Listener) {
+	err := p.gRPCServer.Serve(l)
+	if err != nil {
+		if stderr.Is(err, grpc.ErrServerStopped) {
+			p.log.Info("grpc proxy stopped", "address", l.Addr().String())
+
+			return
+		}
+
+		p.log.Error("grpc proxy error", "address", l.Addr().String(), "error", err)
+	}
+}
+
 // internal
 func (p *Plugin) workers() []*worker.Process {
 	if p == nil || p.pool == nil {
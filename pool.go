@@ -5,6 +5,7 @@ import (
 	"errors"
 	"sync"
 
+	rrerrors "github.com/roadrunner-server/errors"
 	"github.com/roadrunner-server/goridge/v4/pkg/frame"
 	"github.com/roadrunner-server/pool/v2/payload"
 )
@@ -35,7 +36,7 @@ func (p *wrapper) Exec(ctx context.Context, pld *payload.Payload) (*payload.Payl
 	if err != nil {
 		p.putStopCh(sc)
 
-		return nil, err
+		return nil, poolError(err)
 	}
 
 	var resp *payload.Payload
@@ -44,7 +45,7 @@ func (p *wrapper) Exec(ctx context.Context, pld *payload.Payload) (*payload.Payl
 		if pl.Error() != nil {
 			p.putStopCh(sc)
 
-			return nil, pl.Error()
+			return nil, poolError(pl.Error())
 		}
 		// streaming is not supported
 		if pl.Payload().Flags&frame.STREAM != 0 {
@@ -59,7 +60,7 @@ func (p *wrapper) Exec(ctx context.Context, pld *payload.Payload) (*payload.Payl
 	default:
 		p.putStopCh(sc)
 
-		return nil, errors.New("worker empty response")
+		return nil, &workerError{err: errors.New("worker empty response")}
 	}
 
 	p.putStopCh(sc)
@@ -78,3 +79,34 @@ func (p *wrapper) putStopCh(ch chan struct{}) {
 	}
 	p.stopChPool.Put(ch)
 }
+
+// workerError is a failure of the worker rather than of the request, e.g. the process died or
+// returned an empty response. The call may succeed on another worker, so it can be retried.
+type workerError struct {
+	err error
+}
+
+func (e *workerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *workerError) Unwrap() error {
+	return e.err
+}
+
+// poolError classifies an error of the pool. The errors reported by the application (soft job)
+// and the full queue are returned as is, the rest are worker failures.
+func poolError(err error) error {
+	if rrerrors.Is(rrerrors.SoftJob, err) || rrerrors.Is(rrerrors.QueueSize, err) {
+		return err
+	}
+
+	return &workerError{err: err}
+}
+
+// isWorkerError reports whether the error is a worker failure.
+func isWorkerError(err error) bool {
+	var we *workerError
+
+	return errors.As(err, &we)
+}
@@ -2,15 +2,17 @@ package centrifuge
 
 import (
 	"context"
-	"log/slog"
-
 	"encoding/json"
+	"log/slog"
+	"time"
 
+	"github.com/google/uuid"
 	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
 	"github.com/roadrunner-server/errors"
 	"github.com/roadrunner-server/goridge/v4/pkg/frame"
 	"github.com/roadrunner-server/pool/v2/payload"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -18,39 +20,45 @@ type Proxy struct {
 	centrifugov1.UnimplementedCentrifugoProxyServer
 	log *slog.Logger
 	pw  *wrapper
+	red *redactor
+	// per proxy type settings
+	proxies map[string]*ProxyConfig
+	metrics *proxyMetrics
+	// payload context version sent to the workers
+	contextVersion int
+	// handler is the middleware chain ending with exec
+	handler Handler
 }
 
-func (p *Proxy) Connect(ctx context.Context, request *centrifugov1.ConnectRequest) (*centrifugov1.ConnectResponse, error) {
-	p.log.Debug("got connect proxy request")
-	data, err := proto.Marshal(request)
-	if err != nil {
-		return nil, err
+func newProxy(log *slog.Logger, pw *wrapper, red *redactor) *Proxy {
+	p := &Proxy{
+		log:            log,
+		pw:             pw,
+		red:            red,
+		contextVersion: contextV1,
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
-	}
-	md.Append("type", "connect")
+	p.handler = p.exec
 
-	meta, err := json.Marshal(md)
-	if err != nil {
-		return nil, err
-	}
-	pld := &payload.Payload{
-		Context: meta,
-		Body:    data,
-		Codec:   frame.CodecProto,
-	}
+	return p
+}
 
-	re, err := p.pw.Exec(ctx, pld)
+// use wraps the proxy dispatch with the named middleware, the first name being the outermost one.
+func (p *Proxy) use(names []string, mdwr map[string]Middleware) error {
+	h, err := chain(p.handler, names, mdwr)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	cr := &centrifugov1.ConnectResponse{}
-	err = proto.Unmarshal(re.Body, cr)
+	p.handler = h
+
+	return nil
+}
+
+func (p *Proxy) Connect(ctx context.Context, request *centrifugov1.ConnectRequest) (*centrifugov1.ConnectResponse, error) {
+	p.log.Debug("got connect proxy request")
 
+	cr, err := dispatch(ctx, p, connectType, request, &centrifugov1.ConnectResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -61,37 +69,8 @@ func (p *Proxy) Connect(ctx context.Context, request *centrifugov1.ConnectReques
 
 func (p *Proxy) Refresh(ctx context.Context, request *centrifugov1.RefreshRequest) (*centrifugov1.RefreshResponse, error) {
 	p.log.Debug("got refresh proxy request")
-	data, err := proto.Marshal(request)
 
-	if err != nil {
-		return nil, err
-	}
-
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
-	}
-	md.Append("type", "refresh")
-
-	meta, err := json.Marshal(md)
-	if err != nil {
-		return nil, err
-	}
-
-	pld := &payload.Payload{
-		Context: meta,
-		Body:    data,
-		Codec:   frame.CodecProto,
-	}
-
-	re, err := p.pw.Exec(ctx, pld)
-	if err != nil {
-		return nil, err
-	}
-
-	rr := &centrifugov1.RefreshResponse{}
-
-	err = proto.Unmarshal(re.Body, rr)
+	rr, err := dispatch(ctx, p, refreshType, request, &centrifugov1.RefreshResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -102,37 +81,8 @@ func (p *Proxy) Refresh(ctx context.Context, request *centrifugov1.RefreshReques
 
 func (p *Proxy) Subscribe(ctx context.Context, request *centrifugov1.SubscribeRequest) (*centrifugov1.SubscribeResponse, error) {
 	p.log.Debug("got subscribe proxy request")
-	data, err := proto.Marshal(request)
 
-	if err != nil {
-		return nil, err
-	}
-
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
-	}
-	md.Append("type", "subscribe")
-
-	meta, err := json.Marshal(md)
-	if err != nil {
-		return nil, err
-	}
-
-	pld := &payload.Payload{
-		Context: meta,
-		Body:    data,
-		Codec:   frame.CodecProto,
-	}
-
-	re, err := p.pw.Exec(ctx, pld)
-	if err != nil {
-		return nil, err
-	}
-
-	sr := &centrifugov1.SubscribeResponse{}
-
-	err = proto.Unmarshal(re.Body, sr)
+	sr, err := dispatch(ctx, p, subscribeType, request, &centrifugov1.SubscribeResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -143,37 +93,8 @@ func (p *Proxy) Subscribe(ctx context.Context, request *centrifugov1.SubscribeRe
 
 func (p *Proxy) Publish(ctx context.Context, request *centrifugov1.PublishRequest) (*centrifugov1.PublishResponse, error) {
 	p.log.Debug("got publish proxy request")
-	data, err := proto.Marshal(request)
 
-	if err != nil {
-		return nil, err
-	}
-
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
-	}
-	md.Append("type", "publish")
-
-	meta, err := json.Marshal(md)
-	if err != nil {
-		return nil, err
-	}
-
-	pld := &payload.Payload{
-		Context: meta,
-		Body:    data,
-		Codec:   frame.CodecProto,
-	}
-
-	re, err := p.pw.Exec(ctx, pld)
-	if err != nil {
-		return nil, err
-	}
-
-	pr := &centrifugov1.PublishResponse{}
-
-	err = proto.Unmarshal(re.Body, pr)
+	pr, err := dispatch(ctx, p, publishType, request, &centrifugov1.PublishResponse{})
 	if err != nil {
 		return nil, err
 	}
@@ -184,101 +105,111 @@ func (p *Proxy) Publish(ctx context.Context, request *centrifugov1.PublishReques
 
 func (p *Proxy) RPC(ctx context.Context, request *centrifugov1.RPCRequest) (*centrifugov1.RPCResponse, error) {
 	p.log.Debug("got RPC proxy request", "method", request.Method)
-	data, err := proto.Marshal(request)
 
+	rresp, err := dispatch(ctx, p, rpcType, request, &centrifugov1.RPCResponse{})
 	if err != nil {
 		return nil, err
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
-	}
-	md.Append("type", "rpc")
+	p.log.Debug("finished RPC proxy request")
+	return rresp, nil
+}
 
-	meta, err := json.Marshal(md)
+func (p *Proxy) SubRefresh(ctx context.Context, request *centrifugov1.SubRefreshRequest) (*centrifugov1.SubRefreshResponse, error) {
+	p.log.Debug("got RPC SubRefresh request", "channel", request.Channel)
+
+	rresp, err := dispatch(ctx, p, subRefreshType, request, &centrifugov1.SubRefreshResponse{})
 	if err != nil {
 		return nil, err
 	}
 
-	pld := &payload.Payload{
-		Context: meta,
-		Body:    data,
-		Codec:   frame.CodecProto,
-	}
+	p.log.Debug("finished RPC SubRefresh request")
+	return rresp, nil
+}
 
-	re, err := p.pw.Exec(ctx, pld)
+func (p *Proxy) NotifyCacheEmpty(ctx context.Context, request *centrifugov1.NotifyCacheEmptyRequest) (*centrifugov1.NotifyCacheEmptyResponse, error) {
+	p.log.Debug("got NotifyCacheEmpty request")
+
+	rresp, err := dispatch(ctx, p, notifyCacheEmptyType, request, &centrifugov1.NotifyCacheEmptyResponse{})
 	if err != nil {
 		return nil, err
 	}
 
-	rresp := &centrifugov1.RPCResponse{}
+	p.log.Debug("finished NotifyCacheEmpty request")
+	return rresp, nil
+}
 
-	err = proto.Unmarshal(re.Body, rresp)
+func (p *Proxy) NotifyChannelState(ctx context.Context, request *centrifugov1.NotifyChannelStateRequest) (*centrifugov1.NotifyChannelStateResponse, error) {
+	p.log.Debug("got NotifyChannelState request")
+
+	rresp, err := dispatch(ctx, p, notifyChannelStateType, request, &centrifugov1.NotifyChannelStateResponse{})
 	if err != nil {
 		return nil, err
 	}
 
-	p.log.Debug("finished RPC proxy request")
+	p.log.Debug("finished NotifyChannelState request")
 	return rresp, nil
 }
 
-func (p *Proxy) SubRefresh(ctx context.Context, request *centrifugov1.SubRefreshRequest) (*centrifugov1.SubRefreshResponse, error) {
-	p.log.Debug("got RPC SubRefresh request", "channel", request.Channel)
+func (p *Proxy) SubscribeUnidirectional(_ *centrifugov1.SubscribeRequest, _ centrifugov1.CentrifugoProxy_SubscribeUnidirectionalServer) error {
+	p.log.Debug("got SubscribeUnidirectional request")
 
-	data, err := proto.Marshal(request)
-	if err != nil {
-		return nil, err
-	}
+	return errors.Str("not supported")
+}
+
+func (p *Proxy) SubscribeBidirectional(_ centrifugov1.CentrifugoProxy_SubscribeBidirectionalServer) error {
+	p.log.Debug("got StreamSubRequest request")
+
+	return errors.Str("not supported")
+}
+
+// dispatch runs the request through the middleware chain and returns the typed response.
+// resp is an empty response of the type expected for the request.
+func dispatch[T proto.Message](ctx context.Context, p *Proxy, typ string, request proto.Message, resp T) (T, error) {
+	var zero T
 
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		md = metadata.New(nil)
 	}
-	md.Append("type", "subrefresh")
 
-	meta, err := json.Marshal(md)
+	re, err := p.handler(ctx, &Request{
+		ID:         uuid.NewString(),
+		Type:       typ,
+		Message:    request,
+		Meta:       md,
+		ReceivedAt: time.Now(),
+		response:   resp,
+	})
 	if err != nil {
-		return nil, err
-	}
-
-	pld := &payload.Payload{
-		Context: meta,
-		Body:    data,
-		Codec:   frame.CodecProto,
+		return zero, err
 	}
 
-	re, err := p.pw.Exec(ctx, pld)
-	if err != nil {
-		return nil, err
-	}
-
-	rresp := &centrifugov1.SubRefreshResponse{}
-
-	err = proto.Unmarshal(re.Body, rresp)
-	if err != nil {
-		return nil, err
+	out, ok := re.(T)
+	if !ok {
+		return zero, errors.Errorf("unexpected %s proxy response type: %T", typ, re)
 	}
 
-	p.log.Debug("finished RPC SubRefresh request")
-	return rresp, nil
+	return out, nil
 }
 
-func (p *Proxy) NotifyCacheEmpty(ctx context.Context, request *centrifugov1.NotifyCacheEmptyRequest) (*centrifugov1.NotifyCacheEmptyResponse, error) {
-	p.log.Debug("got NotifyCacheEmpty request")
-
-	data, err := proto.Marshal(request)
+// exec is the last handler in the chain, it sends the request to the worker pool.
+func (p *Proxy) exec(ctx context.Context, req *Request) (proto.Message, error) {
+	data, err := proto.Marshal(req.Message)
 	if err != nil {
 		return nil, err
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
+	if p.log.Enabled(ctx, slog.LevelDebug) {
+		p.log.Debug("proxy payload",
+			"request_id", req.ID,
+			"type", req.Type,
+			"metadata", p.red.metadata(req.Meta),
+			"request", protojson.Format(p.red.message(req.Message)),
+		)
 	}
-	md.Append("type", "notifycacheempty")
 
-	meta, err := json.Marshal(md)
+	meta, err := payloadContext(ctx, p.contextVersion, req)
 	if err != nil {
 		return nil, err
 	}
@@ -289,71 +220,65 @@ func (p *Proxy) NotifyCacheEmpty(ctx context.Context, request *centrifugov1.Noti
 		Codec:   frame.CodecProto,
 	}
 
+	start := time.Now()
 	re, err := p.pw.Exec(ctx, pld)
+	req.WorkerPID = 0
+	if err == nil {
+		req.WorkerPID = workerPID(re.Context)
+	}
+	p.observeExec(req, time.Since(start))
 	if err != nil {
 		return nil, err
 	}
 
-	rresp := &centrifugov1.NotifyCacheEmptyResponse{}
-
-	err = proto.Unmarshal(re.Body, rresp)
+	resp := req.NewResponse()
+	err = proto.Unmarshal(re.Body, resp)
 	if err != nil {
 		return nil, err
 	}
 
-	p.log.Debug("finished NotifyCacheEmpty request")
-	return rresp, nil
+	return resp, nil
 }
 
-func (p *Proxy) NotifyChannelState(ctx context.Context, request *centrifugov1.NotifyChannelStateRequest) (*centrifugov1.NotifyChannelStateResponse, error) {
-	p.log.Debug("got NotifyChannelState request")
-
-	data, err := proto.Marshal(request)
-	if err != nil {
-		return nil, err
+// workerPID returns the PID the worker reported in the response context, 0 if there is none.
+func workerPID(meta []byte) int64 {
+	if len(meta) == 0 {
+		return 0
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
+	var rc struct {
+		PID int64 `json:"pid"`
 	}
-	md.Append("type", "notifychannelstate")
 
-	meta, err := json.Marshal(md)
-	if err != nil {
-		return nil, err
+	if json.Unmarshal(meta, &rc) != nil {
+		return 0
 	}
 
-	pld := &payload.Payload{
-		Context: meta,
-		Body:    data,
-		Codec:   frame.CodecProto,
-	}
+	return rc.PID
+}
 
-	re, err := p.pw.Exec(ctx, pld)
-	if err != nil {
-		return nil, err
+// observeExec reports worker executions slower than the configured threshold. The worker PID is
+// logged only when the worker reported it.
+func (p *Proxy) observeExec(req *Request, took time.Duration) {
+	pc, ok := p.proxies[req.Type]
+	if !ok || pc.SlowThreshold == 0 || took <= pc.SlowThreshold {
+		return
 	}
 
-	rresp := &centrifugov1.NotifyChannelStateResponse{}
-
-	err = proto.Unmarshal(re.Body, rresp)
-	if err != nil {
-		return nil, err
+	if p.metrics != nil {
+		p.metrics.slow.WithLabelValues(req.Type).Inc()
 	}
 
-	p.log.Debug("finished NotifyChannelState request")
-	return rresp, nil
-}
-
-func (p *Proxy) SubscribeUnidirectional(_ *centrifugov1.SubscribeRequest, _ centrifugov1.CentrifugoProxy_SubscribeUnidirectionalServer) error {
-	p.log.Debug("got SubscribeUnidirectional request")
-
-	return errors.Str("not supported")
-}
-
-func (p *Proxy) SubscribeBidirectional(_ centrifugov1.CentrifugoProxy_SubscribeBidirectionalServer) error {
-	p.log.Debug("got StreamSubRequest request")
+	args := []any{"request_id", req.ID, "type", req.Type, "duration", took, "threshold", pc.SlowThreshold}
+	if req.WorkerPID != 0 {
+		args = append(args, "worker_pid", req.WorkerPID)
+	}
+	if m, ok := req.Message.(interface{ GetChannel() string }); ok {
+		args = append(args, "channel", m.GetChannel())
+	}
+	if m, ok := req.Message.(interface{ GetMethod() string }); ok {
+		args = append(args, "rpc_method", m.GetMethod())
+	}
 
-	return errors.Str("not supported")
+	p.log.Warn("slow proxy request", args...)
 }
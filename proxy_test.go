@@ -1,10 +1,15 @@
 package centrifuge
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
 	"github.com/roadrunner-server/pool/v2/payload"
@@ -20,23 +25,36 @@ import (
 // success path is not reproducible here.
 type fakePool struct {
 	execErr error
+	// delay simulates a slow worker
+	delay     time.Duration
+	destroyed bool
+	// onReset is called by Reset
+	onReset func()
+	// number of the Exec calls
+	execs atomic.Int32
 }
 
 func (f *fakePool) Workers() []*worker.Process           { return nil }
 func (f *fakePool) RemoveWorker(_ context.Context) error { return nil }
 func (f *fakePool) AddWorker() error                     { return nil }
-func (f *fakePool) Reset(_ context.Context) error        { return nil }
-func (f *fakePool) Destroy(_ context.Context)            {}
+func (f *fakePool) Reset(_ context.Context) error {
+	if f.onReset != nil {
+		f.onReset()
+	}
+
+	return nil
+}
+func (f *fakePool) Destroy(_ context.Context) { f.destroyed = true }
 
 func (f *fakePool) Exec(_ context.Context, _ *payload.Payload, _ chan struct{}) (chan *staticPool.PExec, error) {
+	f.execs.Add(1)
+	time.Sleep(f.delay)
+
 	return nil, f.execErr
 }
 
 func newTestProxy() *Proxy {
-	return &Proxy{
-		log: testLogger(),
-		pw:  newPoolMuWrapper(&fakePool{execErr: errors.New("exec failed")}, &sync.RWMutex{}),
-	}
+	return newProxy(testLogger(), newPoolMuWrapper(&fakePool{execErr: errors.New("exec failed")}, &sync.RWMutex{}), newRedactor(nil))
 }
 
 // TestProxyMethodsMetadataGuard exercises every proxy handler with and without
@@ -89,3 +107,49 @@ func TestProxyStreamingNotSupported(t *testing.T) {
 	require.Error(t, p.SubscribeUnidirectional(&centrifugov1.SubscribeRequest{}, nil))
 	require.Error(t, p.SubscribeBidirectional(nil))
 }
+
+func TestProxySlowThreshold(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	p := newProxy(
+		slog.New(slog.NewTextHandler(buf, nil)),
+		newPoolMuWrapper(&fakePool{execErr: errors.New("exec failed"), delay: time.Millisecond * 20}, &sync.RWMutex{}),
+		newRedactor(nil),
+	)
+	p.metrics = newProxyMetrics()
+	p.proxies = map[string]*ProxyConfig{
+		subscribeType: {SlowThreshold: time.Millisecond},
+		connectType:   {SlowThreshold: time.Minute},
+	}
+
+	_, err := p.Subscribe(t.Context(), &centrifugov1.SubscribeRequest{Channel: "chat"})
+	require.Error(t, err)
+	_, err = p.Connect(t.Context(), &centrifugov1.ConnectRequest{})
+	require.Error(t, err)
+	// no threshold configured for refresh
+	_, err = p.Refresh(t.Context(), &centrifugov1.RefreshRequest{})
+	require.Error(t, err)
+
+	require.Equal(t, 1, strings.Count(buf.String(), "slow proxy request"))
+	require.Contains(t, buf.String(), "level=WARN")
+	require.Contains(t, buf.String(), "type=subscribe")
+	require.Contains(t, buf.String(), "channel=chat")
+	// the PID is unknown, the failed call has no worker
+	require.NotContains(t, buf.String(), "worker_pid")
+
+	// only the subscribe series was created
+	require.Equal(t, 1, collectCount(t, p.metrics.slow))
+
+	// the PID reported by the worker
+	buf.Reset()
+	p.observeExec(&Request{ID: "req-1", Type: subscribeType, Message: &centrifugov1.SubscribeRequest{Channel: "chat"}, WorkerPID: 1234}, time.Second)
+	require.Contains(t, buf.String(), "worker_pid=1234")
+}
+
+func TestWorkerPID(t *testing.T) {
+	require.Equal(t, int64(1234), workerPID([]byte(`{"pid":1234}`)))
+	require.Equal(t, int64(1234), workerPID([]byte(`{"pid":1234,"other":true}`)))
+	require.Zero(t, workerPID(nil))
+	require.Zero(t, workerPID([]byte(`{}`)))
+	require.Zero(t, workerPID([]byte("not json")))
+}
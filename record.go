@@ -0,0 +1,316 @@
+package centrifuge
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// record is a single recorded proxy call. It is stored as a length-delimited protobuf message:
+//
+//	message Record {
+//	  int64 received_at = 1; // unix nanoseconds
+//	  int64 duration = 2;    // nanoseconds
+//	  string request_id = 3;
+//	  string type = 4;
+//	  bytes metadata = 5;    // JSON encoded gRPC metadata
+//	  bytes request = 6;     // Centrifugo proxy request
+//	  bytes response = 7;    // Centrifugo proxy response, empty on error
+//	  string error = 8;
+//	}
+type record struct {
+	receivedAt time.Time
+	duration   time.Duration
+	requestID  string
+	typ        string
+	metadata   []byte
+	request    []byte
+	response   []byte
+	err        string
+}
+
+func (r *record) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(r.receivedAt.UnixNano())) //nolint:gosec
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(r.duration)) //nolint:gosec
+	b = appendString(b, 3, r.requestID)
+	b = appendString(b, 4, r.typ)
+	b = appendBytes(b, 5, r.metadata)
+	b = appendBytes(b, 6, r.request)
+	b = appendBytes(b, 7, r.response)
+	b = appendString(b, 8, r.err)
+
+	return b
+}
+
+func unmarshalRecord(b []byte) (*record, error) {
+	r := &record{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.VarintType && (num == 1 || num == 2):
+			v, m := protowire.ConsumeVarint(b)
+			if m < 0 {
+				return nil, protowire.ParseError(m)
+			}
+
+			if num == 1 {
+				r.receivedAt = time.Unix(0, int64(v)) //nolint:gosec
+			} else {
+				r.duration = time.Duration(v) //nolint:gosec
+			}
+
+			n = m
+		case typ == protowire.BytesType && num >= 3 && num <= 8:
+			v, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return nil, protowire.ParseError(m)
+			}
+
+			switch num {
+			case 3:
+				r.requestID = string(v)
+			case 4:
+				r.typ = string(v)
+			case 5:
+				r.metadata = v
+			case 6:
+				r.request = v
+			case 7:
+				r.response = v
+			case 8:
+				r.err = string(v)
+			}
+
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+		}
+
+		b = b[n:]
+	}
+
+	return r, nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendBytes(b, v)
+}
+
+// readRecord reads the next length-delimited record, io.EOF is returned at the end of the recording.
+func readRecord(r *bufio.Reader) (*record, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalRecord(b)
+}
+
+// recorder writes every proxy call reaching the workers to a local file, rotating it by size.
+// Requests and metadata are redacted before they are written.
+type recorder struct {
+	mu  sync.Mutex
+	log *slog.Logger
+	red *redactor
+
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func newRecorder(cfg *Record, red *redactor, log *slog.Logger) (*recorder, error) {
+	r := &recorder{
+		log:      log,
+		red:      red,
+		path:     cfg.Path,
+		maxSize:  int64(cfg.MaxSize) * 1024 * 1024,
+		maxFiles: cfg.MaxFiles,
+	}
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Middleware records the calls, it is installed next to the worker pool, so the recording
+// contains the requests as the workers received them.
+func (r *recorder) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		start := time.Now()
+		resp, err := next(ctx, req)
+		r.record(req, resp, err, time.Since(start))
+
+		return resp, err
+	}
+}
+
+func (r *recorder) record(req *Request, resp proto.Message, execErr error, took time.Duration) {
+	rec := &record{
+		receivedAt: req.ReceivedAt,
+		duration:   took,
+		requestID:  req.ID,
+		typ:        req.Type,
+	}
+
+	var err error
+	rec.metadata, err = json.Marshal(r.red.metadata(req.Meta))
+	if err != nil {
+		r.log.Error("failed to encode recorded metadata", "error", err)
+		return
+	}
+
+	rec.request, err = proto.Marshal(r.red.message(req.Message))
+	if err != nil {
+		r.log.Error("failed to encode recorded request", "error", err)
+		return
+	}
+
+	if execErr != nil {
+		rec.err = execErr.Error()
+	} else if resp != nil {
+		rec.response, err = proto.Marshal(resp)
+		if err != nil {
+			r.log.Error("failed to encode recorded response", "error", err)
+			return
+		}
+	}
+
+	err = r.write(rec.marshal())
+	if err != nil {
+		r.log.Error("failed to record proxy call", "error", err)
+	}
+}
+
+func (r *recorder) write(b []byte) error {
+	buf := protowire.AppendVarint(make([]byte, 0, len(b)+binary.MaxVarintLen64), uint64(len(b)))
+	buf = append(buf, b...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return errors.Str("recorder is closed")
+	}
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(buf)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(buf)
+	r.size += int64(n)
+
+	return err
+}
+
+func (r *recorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = st.Size()
+
+	return nil
+}
+
+// rotate renames path.N-1 to path.N, ..., path to path.1 (the oldest file is overwritten) and opens a new file.
+func (r *recorder) rotate() error {
+	err := r.file.Close()
+	if err != nil {
+		return err
+	}
+
+	for i := r.maxFiles; i > 1; i-- {
+		err = os.Rename(fmt.Sprintf("%s.%d", r.path, i-1), fmt.Sprintf("%s.%d", r.path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if r.maxFiles > 0 {
+		err = os.Rename(r.path, r.path+".1")
+	} else {
+		err = os.Remove(r.path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
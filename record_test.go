@@ -0,0 +1,140 @@
+package centrifuge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRecordMarshal(t *testing.T) {
+	in := &record{
+		receivedAt: time.Unix(0, 1700000000123456789),
+		duration:   time.Millisecond * 5,
+		requestID:  "req-1",
+		typ:        connectType,
+		metadata:   []byte(`{"origin":["example.com"]}`),
+		request:    []byte{0x0a, 0x01, 'c'},
+		response:   []byte{0x12, 0x00},
+		err:        "boom",
+	}
+
+	out, err := unmarshalRecord(in.marshal())
+	require.NoError(t, err)
+
+	assert.True(t, in.receivedAt.Equal(out.receivedAt))
+	assert.Equal(t, in.duration, out.duration)
+	assert.Equal(t, in.requestID, out.requestID)
+	assert.Equal(t, in.typ, out.typ)
+	assert.Equal(t, in.metadata, out.metadata)
+	assert.Equal(t, in.request, out.request)
+	assert.Equal(t, in.response, out.response)
+	assert.Equal(t, in.err, out.err)
+}
+
+// readAll returns every record in the file at path.
+func readAll(t *testing.T, path string) []*record {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	br := bufio.NewReader(f)
+
+	var recs []*record
+	for {
+		rec, err := readRecord(br)
+		if errors.Is(err, io.EOF) {
+			return recs
+		}
+		require.NoError(t, err)
+
+		recs = append(recs, rec)
+	}
+}
+
+func TestRecorderMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.rec")
+
+	rec, err := newRecorder(&Record{Path: path, MaxSize: 100, MaxFiles: 5}, newRedactor(&Redact{Paths: []string{"token"}}), testLogger())
+	require.NoError(t, err)
+
+	h := rec.Middleware(func(_ context.Context, req *Request) (proto.Message, error) {
+		if req.Type == refreshType {
+			return nil, errors.New("worker empty response")
+		}
+
+		return &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1"}}, nil
+	})
+
+	_, err = h(t.Context(), &Request{
+		ID:         "req-1",
+		Type:       connectType,
+		Message:    &centrifugov1.ConnectRequest{Data: []byte(`{"token":"secret"}`)},
+		Meta:       metadata.Pairs("authorization", "bearer secret"),
+		ReceivedAt: time.Now(),
+	})
+	require.NoError(t, err)
+
+	_, err = h(t.Context(), &Request{ID: "req-2", Type: refreshType, Message: &centrifugov1.RefreshRequest{User: "u1"}})
+	require.Error(t, err)
+
+	require.NoError(t, rec.close())
+
+	recs := readAll(t, path)
+	require.Len(t, recs, 2)
+
+	assert.Equal(t, "req-1", recs[0].requestID)
+	assert.Equal(t, connectType, recs[0].typ)
+	assert.NotContains(t, string(recs[0].metadata), "bearer secret")
+	assert.NotContains(t, string(recs[0].request), "secret")
+
+	md := map[string][]string{}
+	require.NoError(t, json.Unmarshal(recs[0].metadata, &md))
+	assert.Equal(t, []string{redactedValue}, md["authorization"])
+
+	resp := &centrifugov1.ConnectResponse{}
+	require.NoError(t, proto.Unmarshal(recs[0].response, resp))
+	assert.Equal(t, "u1", resp.GetResult().GetUser())
+
+	assert.Equal(t, "worker empty response", recs[1].err)
+	assert.Empty(t, recs[1].response)
+}
+
+func TestRecorderRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.rec")
+
+	rec, err := newRecorder(&Record{Path: path, MaxSize: 1, MaxFiles: 2}, newRedactor(nil), testLogger())
+	require.NoError(t, err)
+	// rotate on every write
+	rec.maxSize = 1
+
+	for range 4 {
+		require.NoError(t, rec.write([]byte("x")))
+	}
+	require.NoError(t, rec.close())
+
+	// the current file and two rotated ones, the oldest record was dropped
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		st, err := os.Stat(p)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), st.Size())
+	}
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	require.Error(t, rec.write([]byte("x")))
+}
@@ -0,0 +1,164 @@
+package centrifuge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// redactedValue replaces sensitive values
+const redactedValue string = "[REDACTED]"
+
+// defaultRedactedMetadata lists metadata keys that are always masked
+func defaultRedactedMetadata() []string {
+	return []string{"authorization", "cookie", "set-cookie"}
+}
+
+// redactor masks credentials in everything the plugin writes out: access log records,
+// debug payload dumps and recorded traffic. It never modifies the request sent to the worker.
+type redactor struct {
+	// lower-cased metadata keys
+	keys []string
+	// JSON paths inside the request data, split by '.'
+	paths [][]string
+}
+
+func newRedactor(cfg *Redact) *redactor {
+	r := &redactor{
+		keys: defaultRedactedMetadata(),
+	}
+
+	if cfg == nil {
+		return r
+	}
+
+	for _, k := range cfg.Metadata {
+		k = strings.ToLower(k)
+		if !slices.Contains(r.keys, k) {
+			r.keys = append(r.keys, k)
+		}
+	}
+
+	for _, p := range cfg.Paths {
+		r.paths = append(r.paths, strings.Split(p, "."))
+	}
+
+	return r
+}
+
+// metadata returns a copy of md with the sensitive keys masked.
+func (r *redactor) metadata(md metadata.MD) metadata.MD {
+	out := md.Copy()
+
+	for k, v := range out {
+		if !slices.Contains(r.keys, strings.ToLower(k)) {
+			continue
+		}
+
+		masked := make([]string, len(v))
+		for i := range masked {
+			masked[i] = redactedValue
+		}
+
+		out[k] = masked
+	}
+
+	return out
+}
+
+// data masks the configured JSON paths in the request data. Data that is not a JSON
+// document cannot be inspected, so it is masked as a whole when any path is configured.
+func (r *redactor) data(data []byte) []byte {
+	if len(r.paths) == 0 || len(data) == 0 {
+		return data
+	}
+
+	var doc any
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return []byte(redactedValue)
+	}
+
+	for _, p := range r.paths {
+		doc = maskPath(doc, p)
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return []byte(redactedValue)
+	}
+
+	return out
+}
+
+// message returns a redacted copy of the Centrifugo request: the data (and b64data)
+// fields are masked by the configured JSON paths, the subscription token is always masked.
+func (r *redactor) message(msg proto.Message) proto.Message {
+	out := proto.Clone(msg)
+	m := out.ProtoReflect()
+	fields := m.Descriptor().Fields()
+
+	if fd := fields.ByName("data"); fd != nil && fd.Kind() == protoreflect.BytesKind && m.Has(fd) {
+		m.Set(fd, protoreflect.ValueOfBytes(r.data(m.Get(fd).Bytes())))
+	}
+
+	if fd := fields.ByName("b64data"); fd != nil && fd.Kind() == protoreflect.StringKind && m.Has(fd) {
+		m.Set(fd, protoreflect.ValueOfString(r.b64data(m.Get(fd).String())))
+	}
+
+	if fd := fields.ByName("token"); fd != nil && fd.Kind() == protoreflect.StringKind && m.Has(fd) {
+		m.Set(fd, protoreflect.ValueOfString(redactedValue))
+	}
+
+	return out
+}
+
+func (r *redactor) b64data(s string) string {
+	if len(r.paths) == 0 {
+		return s
+	}
+
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return redactedValue
+	}
+
+	return base64.StdEncoding.EncodeToString(r.data(data))
+}
+
+// maskPath replaces the value at path with redactedValue, '*' matches every key or array element.
+func maskPath(doc any, path []string) any {
+	if len(path) == 0 {
+		return redactedValue
+	}
+
+	switch v := doc.(type) {
+	case map[string]any:
+		if path[0] == "*" {
+			for k := range v {
+				v[k] = maskPath(v[k], path[1:])
+			}
+
+			return v
+		}
+
+		if val, ok := v[path[0]]; ok {
+			v[path[0]] = maskPath(val, path[1:])
+		}
+	case []any:
+		if path[0] != "*" {
+			return v
+		}
+
+		for i := range v {
+			v[i] = maskPath(v[i], path[1:])
+		}
+	}
+
+	return doc
+}
@@ -0,0 +1,95 @@
+package centrifuge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"sync"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRedactMetadata(t *testing.T) {
+	r := newRedactor(&Redact{Metadata: []string{"X-Api-Key"}})
+
+	md := metadata.Pairs("authorization", "bearer secret", "x-api-key", "k", "origin", "example.com")
+	out := r.metadata(md)
+
+	assert.Equal(t, []string{redactedValue}, out.Get("authorization"))
+	assert.Equal(t, []string{redactedValue}, out.Get("x-api-key"))
+	assert.Equal(t, []string{"example.com"}, out.Get("origin"))
+	// the original metadata is still sent to the worker
+	assert.Equal(t, []string{"bearer secret"}, md.Get("authorization"))
+}
+
+func TestRedactData(t *testing.T) {
+	r := newRedactor(&Redact{Paths: []string{"token", "auth.password", "devices.*.secret"}})
+
+	out := r.data([]byte(`{"token":"t","auth":{"user":"u","password":"p"},"devices":[{"id":1,"secret":"s"}]}`))
+
+	assert.JSONEq(t, `{"token":"[REDACTED]","auth":{"user":"u","password":"[REDACTED]"},"devices":[{"id":1,"secret":"[REDACTED]"}]}`, string(out))
+	assert.Equal(t, redactedValue, string(r.data([]byte("not json"))))
+	// no paths configured: data is left as is
+	assert.Equal(t, "not json", string(newRedactor(nil).data([]byte("not json"))))
+}
+
+func TestRedactMessage(t *testing.T) {
+	r := newRedactor(&Redact{Paths: []string{"token"}})
+
+	in := &centrifugov1.SubscribeRequest{
+		Channel: "chat",
+		Token:   "sub-token",
+		Data:    []byte(`{"token":"t"}`),
+		B64Data: base64.StdEncoding.EncodeToString([]byte(`{"token":"t"}`)),
+	}
+
+	out := r.message(in).(*centrifugov1.SubscribeRequest)
+
+	assert.Equal(t, "chat", out.GetChannel())
+	assert.Equal(t, redactedValue, out.GetToken())
+	assert.JSONEq(t, `{"token":"[REDACTED]"}`, string(out.GetData()))
+
+	b64, err := base64.StdEncoding.DecodeString(out.GetB64Data())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"token":"[REDACTED]"}`, string(b64))
+
+	// the request itself is untouched
+	assert.Equal(t, "sub-token", in.GetToken())
+	assert.JSONEq(t, `{"token":"t"}`, string(in.GetData()))
+}
+
+func TestRedactAccessLog(t *testing.T) {
+	req := &Request{
+		Type:    connectType,
+		Message: &centrifugov1.ConnectRequest{Data: []byte(`{"token":"t","name":"n"}`)},
+		Meta:    metadata.Pairs("cookie", "session=1"),
+	}
+
+	rec := logRecord(t, []string{fieldMetadata, fieldData}, req, func(_ context.Context, _ *Request) (proto.Message, error) {
+		return &centrifugov1.ConnectResponse{}, nil
+	})
+
+	assert.Equal(t, map[string]any{"cookie": []any{redactedValue}}, rec[fieldMetadata])
+	assert.JSONEq(t, `{"token":"[REDACTED]","name":"n"}`, rec[fieldData].(string))
+}
+
+func TestRedactPayloadDump(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	p := newProxy(log, newPoolMuWrapper(&fakePool{execErr: assert.AnError}, &sync.RWMutex{}), newRedactor(&Redact{Paths: []string{"password"}}))
+
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs("authorization", "bearer secret"))
+	_, err := p.Connect(ctx, &centrifugov1.ConnectRequest{Data: []byte(`{"password":"hunter2"}`)})
+	require.Error(t, err)
+
+	assert.Contains(t, buf.String(), "proxy payload")
+	assert.NotContains(t, buf.String(), "bearer secret")
+	assert.NotContains(t, buf.String(), "hunter2")
+}
@@ -0,0 +1,157 @@
+package centrifuge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	stderr "errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roadrunner-server/errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ReplayRequest is the Replay RPC request.
+type ReplayRequest struct {
+	// Path to the recording
+	Path string `json:"path"`
+}
+
+// ReplayReport is the result of replaying a recording against the worker pool.
+type ReplayReport struct {
+	// Total number of records in the recording
+	Total int `json:"total"`
+	// Matched records got the same response (or also failed)
+	Matched int `json:"matched"`
+	// Diverged records got a different response, see Diffs
+	Diverged int `json:"diverged"`
+	// Skipped records could not be decoded
+	Skipped int          `json:"skipped"`
+	Diffs   []ReplayDiff `json:"diffs"`
+}
+
+// ReplayDiff describes a single diverged record. Responses are in protobuf JSON,
+// the errors are set when the call failed instead.
+type ReplayDiff struct {
+	RequestID     string `json:"request_id"`
+	Type          string `json:"type"`
+	Recorded      string `json:"recorded,omitempty"`
+	RecordedError string `json:"recorded_error,omitempty"`
+	Replayed      string `json:"replayed,omitempty"`
+	ReplayedError string `json:"replayed_error,omitempty"`
+}
+
+// replayFile replays the recording at path, see replay.
+func replayFile(ctx context.Context, path string, h Handler) (*ReplayReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	return replay(ctx, f, h)
+}
+
+// replay sends every recorded request to h, one by one, and compares the responses with the recorded ones.
+// Recordings are redacted, so calls depending on masked values (e.g. tokens) are expected to diverge.
+func replay(ctx context.Context, r io.Reader, h Handler) (*ReplayReport, error) {
+	const op = errors.Op("centrifuge_replay")
+
+	br := bufio.NewReader(r)
+	report := &ReplayReport{}
+
+	for {
+		rec, err := readRecord(br)
+		if err != nil {
+			if stderr.Is(err, io.EOF) {
+				return report, nil
+			}
+
+			return report, errors.E(op, err)
+		}
+
+		report.Total++
+
+		req, recorded, err := replayRequest(rec)
+		if err != nil {
+			report.Skipped++
+			continue
+		}
+
+		replayed, errR := h(ctx, req)
+
+		switch {
+		case rec.err != "" && errR != nil:
+			report.Matched++
+		case rec.err == "" && errR == nil && proto.Equal(recorded, replayed):
+			report.Matched++
+		default:
+			report.Diverged++
+			report.Diffs = append(report.Diffs, replayDiff(rec, recorded, replayed, errR))
+		}
+	}
+}
+
+// replayRequest decodes the recorded request and response.
+func replayRequest(rec *record) (*Request, proto.Message, error) {
+	msg, resp, ok := proxyMessages(rec.typ)
+	if !ok {
+		return nil, nil, errors.Errorf("unknown proxy type '%s'", rec.typ)
+	}
+
+	err := proto.Unmarshal(rec.request, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	md := metadata.MD{}
+	if len(rec.metadata) > 0 {
+		err = json.Unmarshal(rec.metadata, &md)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	recorded := proto.Clone(resp)
+	err = proto.Unmarshal(rec.response, recorded)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &Request{
+		ID:         uuid.NewString(),
+		Type:       rec.typ,
+		Message:    msg,
+		Meta:       md,
+		ReceivedAt: time.Now(),
+		response:   resp,
+	}, recorded, nil
+}
+
+func replayDiff(rec *record, recorded, replayed proto.Message, errR error) ReplayDiff {
+	d := ReplayDiff{
+		RequestID:     rec.requestID,
+		Type:          rec.typ,
+		RecordedError: rec.err,
+	}
+
+	if rec.err == "" {
+		d.Recorded = protojson.Format(recorded)
+	}
+
+	if errR != nil {
+		d.ReplayedError = errR.Error()
+	} else if replayed != nil {
+		d.Replayed = protojson.Format(replayed)
+	}
+
+	return d
+}
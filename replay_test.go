@@ -0,0 +1,76 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// subscribeWorker allows every channel except the denied one.
+func subscribeWorker(denied string) Handler {
+	return func(_ context.Context, req *Request) (proto.Message, error) {
+		ch := req.Message.(*centrifugov1.SubscribeRequest).GetChannel()
+
+		switch ch {
+		case "broken":
+			return nil, errors.New("worker empty response")
+		case denied:
+			return &centrifugov1.SubscribeResponse{Error: &centrifugov1.Error{Code: 103, Message: "permission denied"}}, nil
+		default:
+			return &centrifugov1.SubscribeResponse{Result: &centrifugov1.SubscribeResult{}}, nil
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.rec")
+
+	rec, err := newRecorder(&Record{Path: path, MaxSize: 100, MaxFiles: 1}, newRedactor(nil), testLogger())
+	require.NoError(t, err)
+
+	// record the traffic served by the old worker code, which denies "admin"
+	h := rec.Middleware(subscribeWorker("admin"))
+	for _, ch := range []string{"news", "admin", "broken"} {
+		_, _ = h(t.Context(), &Request{
+			ID:         ch,
+			Type:       subscribeType,
+			Message:    &centrifugov1.SubscribeRequest{Channel: ch},
+			ReceivedAt: time.Now(),
+		})
+	}
+	require.NoError(t, rec.close())
+
+	// the new worker code denies "news" instead
+	report, err := replayFile(t.Context(), path, subscribeWorker("news"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 1, report.Matched)
+	assert.Equal(t, 2, report.Diverged)
+	assert.Equal(t, 0, report.Skipped)
+
+	require.Len(t, report.Diffs, 2)
+	assert.Equal(t, "news", report.Diffs[0].RequestID)
+	assert.Equal(t, subscribeType, report.Diffs[0].Type)
+	assert.Contains(t, report.Diffs[0].Replayed, "permission denied")
+	assert.Equal(t, "admin", report.Diffs[1].RequestID)
+	assert.Contains(t, report.Diffs[1].Recorded, "permission denied")
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	_, err := replayFile(t.Context(), filepath.Join(t.TempDir(), "absent.rec"), nil)
+	require.Error(t, err)
+}
+
+func TestPluginReplayNotReady(t *testing.T) {
+	_, err := (&Plugin{}).replay(t.Context(), "proxy.rec")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "RoadRunner is not ready yet")
+}
@@ -0,0 +1,135 @@
+package centrifuge
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// idempotentTypes are the proxy types that are safe to send to a worker again
+func idempotentTypes() []string {
+	return []string{connectType, refreshType, subscribeType, subRefreshType}
+}
+
+// retry results
+const (
+	retryAttempted string = "attempted"
+	// the retry was skipped because the retry budget was exhausted
+	retryExhausted string = "budget_exhausted"
+)
+
+// retryBudget limits the retries to a share of the calls: each call deposits `ratio` tokens,
+// each retry takes one. The budget starts full and holds at most `burst` tokens.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	burst  float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64, burst int) *retryBudget {
+	return &retryBudget{
+		ratio:  ratio,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	b.tokens = min(b.tokens+b.ratio, b.burst)
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// retrier sends the call to the workers again when the worker failed to produce a response,
+// e.g. the process died or returned an empty response. Centrifugo errors and disconnects returned
+// by the application are responses and are never retried, as well as the errors of the request
+// itself (an invalid worker response, a full queue, an application error).
+type retrier struct {
+	log     *slog.Logger
+	metrics *proxyMetrics
+	byType  map[string]*Retry
+	budgets map[string]*retryBudget
+}
+
+func newRetrier(proxies map[string]*ProxyConfig, metrics *proxyMetrics, log *slog.Logger) *retrier {
+	r := &retrier{
+		log:     log,
+		metrics: metrics,
+		byType:  make(map[string]*Retry),
+		budgets: make(map[string]*retryBudget),
+	}
+
+	for typ, pc := range proxies {
+		if pc.Retry != nil {
+			r.byType[typ] = pc.Retry
+			r.budgets[typ] = newRetryBudget(pc.Retry.Budget, pc.Retry.BudgetBurst)
+		}
+	}
+
+	return r
+}
+
+func (r *retrier) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		cfg, ok := r.byType[req.Type]
+		if !ok {
+			return next(ctx, req)
+		}
+
+		budget := r.budgets[req.Type]
+		budget.deposit()
+
+		resp, err := next(ctx, req)
+		// only the worker failures, the request errors fail the same way on every attempt
+		for attempt := 2; isWorkerError(err) && attempt <= cfg.MaxAttempts; attempt++ {
+			if ctx.Err() != nil {
+				break
+			}
+
+			if !budget.withdraw() {
+				r.observe(req.Type, retryExhausted)
+				r.log.Warn("proxy retry budget exhausted", "request_id", req.ID, "type", req.Type, "error", err)
+
+				break
+			}
+
+			r.observe(req.Type, retryAttempted)
+			r.log.Warn("retrying proxy request", "request_id", req.ID, "type", req.Type, "attempt", attempt, "error", err)
+
+			if cfg.Backoff > 0 {
+				select {
+				case <-ctx.Done():
+					return nil, err
+				case <-time.After(cfg.Backoff):
+				}
+			}
+
+			resp, err = next(ctx, req)
+		}
+
+		return resp, err
+	}
+}
+
+func (r *retrier) observe(typ, result string) {
+	if r.metrics != nil {
+		r.metrics.retries.WithLabelValues(typ, result).Inc()
+	}
+}
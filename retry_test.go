@@ -0,0 +1,125 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	rrerrors "github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/pool/v2/payload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// failingWorker fails the first `failures` calls.
+type failingWorker struct {
+	failures int
+	calls    int
+}
+
+func (f *failingWorker) handle(context.Context, *Request) (proto.Message, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, &workerError{err: errors.New("worker empty response")}
+	}
+
+	return &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1"}}, nil
+}
+
+func newTestRetrier(t *testing.T, rc *Retry) *retrier {
+	t.Helper()
+
+	require.NoError(t, rc.InitDefaults())
+
+	return newRetrier(map[string]*ProxyConfig{connectType: {Retry: rc}}, newProxyMetrics(), testLogger())
+}
+
+func TestRetryWorkerFailure(t *testing.T) {
+	r := newTestRetrier(t, &Retry{MaxAttempts: 3})
+
+	w := &failingWorker{failures: 2}
+	resp, err := r.Middleware(w.handle)(t.Context(), connectRequest())
+	require.NoError(t, err)
+	assert.Equal(t, "u1", resp.(*centrifugov1.ConnectResponse).GetResult().GetUser())
+	assert.Equal(t, 3, w.calls)
+	assert.InDelta(t, 2, counterValue(t, r.metrics.retries.WithLabelValues(connectType, retryAttempted)), 0)
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	r := newTestRetrier(t, &Retry{MaxAttempts: 2})
+
+	w := &failingWorker{failures: 5}
+	_, err := r.Middleware(w.handle)(t.Context(), connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, 2, w.calls)
+}
+
+func TestRetryDenialNotRetried(t *testing.T) {
+	r := newTestRetrier(t, &Retry{})
+
+	var calls int
+	h := r.Middleware(func(context.Context, *Request) (proto.Message, error) {
+		calls++
+		return &centrifugov1.ConnectResponse{Error: &centrifugov1.Error{Code: 103}}, nil
+	})
+
+	_, err := h(t.Context(), connectRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryBudget(t *testing.T) {
+	r := newTestRetrier(t, &Retry{MaxAttempts: 2, Budget: 0.5, BudgetBurst: 1})
+	h := r.Middleware((&failingWorker{failures: 100}).handle)
+
+	// the budget starts full with a single retry
+	_, _ = h(t.Context(), connectRequest())
+	// 0.5 tokens are left after the deposit, no retry
+	_, _ = h(t.Context(), connectRequest())
+	// the second deposit earns a retry again
+	_, _ = h(t.Context(), connectRequest())
+
+	assert.InDelta(t, 2, counterValue(t, r.metrics.retries.WithLabelValues(connectType, retryAttempted)), 0)
+	assert.InDelta(t, 1, counterValue(t, r.metrics.retries.WithLabelValues(connectType, retryExhausted)), 0)
+}
+
+func TestRetryCanceled(t *testing.T) {
+	r := newTestRetrier(t, &Retry{MaxAttempts: 5})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	w := &failingWorker{failures: 5}
+	_, err := r.Middleware(w.handle)(ctx, connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, 1, w.calls)
+}
+
+func TestRetryRequestErrorNotRetried(t *testing.T) {
+	r := newTestRetrier(t, &Retry{MaxAttempts: 3})
+
+	var calls int
+	_, err := r.Middleware(func(context.Context, *Request) (proto.Message, error) {
+		calls++
+		return nil, errors.New("streaming response not supported")
+	})(t.Context(), connectRequest())
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestPoolErrorClassification(t *testing.T) {
+	assert.True(t, isWorkerError(poolError(rrerrors.E(rrerrors.Op("exec"), rrerrors.Network, errors.New("broken pipe")))))
+	assert.True(t, isWorkerError(poolError(errors.New("worker stopped"))))
+	assert.False(t, isWorkerError(poolError(rrerrors.E(rrerrors.Op("exec"), rrerrors.SoftJob, errors.New("application error")))))
+	assert.False(t, isWorkerError(poolError(rrerrors.E(rrerrors.Op("exec"), rrerrors.QueueSize, errors.New("max queue size reached")))))
+	assert.False(t, isWorkerError(errors.New("proto: cannot parse invalid wire-format data")))
+
+	// the pool failures are worker failures
+	pw := newPoolMuWrapper(&fakePool{execErr: errors.New("worker stopped")}, &sync.RWMutex{})
+	_, err := pw.Exec(t.Context(), &payload.Payload{Body: []byte("x")})
+	assert.True(t, isWorkerError(err))
+	assert.EqualError(t, err, "worker stopped")
+}
@@ -3,6 +3,7 @@ package centrifuge
 import (
 	"context"
 	"log/slog"
+	"time"
 
 	v1Client "github.com/roadrunner-server/api-go/v6/centrifugo/api/v1"
 	"github.com/roadrunner-server/errors"
@@ -10,6 +11,7 @@ import (
 
 type rpc struct {
 	client *client
+	plugin *Plugin
 	log    *slog.Logger
 }
 
@@ -73,12 +75,20 @@ func (r *rpc) Batch(in *v1Client.BatchRequest, out *v1Client.BatchResponse) erro
 func (r *rpc) Publish(in *v1Client.PublishRequest, out *v1Client.PublishResponse) error {
 	r.log.Debug("got publish request")
 
-	client := r.client.client()
-	if client == nil {
-		return errors.Str("RoadRunner is not ready yet, try in a few seconds")
+	ctx := context.Background()
+	call := func() (*v1Client.PublishResponse, error) {
+		return r.client.publish(ctx, in)
 	}
 
-	resp, err := client.Publish(context.Background(), in)
+	var resp *v1Client.PublishResponse
+	var err error
+	if r.plugin.idempotency != nil {
+		var keep bool
+		in.IdempotencyKey, keep = r.plugin.idempotency.publishKey(in)
+		resp, err = idempotentCall(ctx, r.plugin.idempotency, in.GetIdempotencyKey(), keep, call)
+	} else {
+		resp, err = call()
+	}
 	if err != nil {
 		return err
 	}
@@ -86,16 +96,30 @@ func (r *rpc) Publish(in *v1Client.PublishRequest, out *v1Client.PublishResponse
 	out.Error = resp.GetError()
 	out.Result = resp.GetResult()
 
+	if r.plugin.cache != nil && resp.GetError() == nil {
+		r.plugin.cache.store(in)
+	}
+
 	return nil
 }
 
 func (r *rpc) Broadcast(in *v1Client.BroadcastRequest, out *v1Client.BroadcastResponse) error {
 	r.log.Debug("got broadcast request")
-	client := r.client.client()
-	if client == nil {
-		return errors.Str("RoadRunner is not ready yet, try in a few seconds")
+
+	ctx := context.Background()
+	call := func() (*v1Client.BroadcastResponse, error) {
+		return r.client.broadcast(ctx, in)
+	}
+
+	var resp *v1Client.BroadcastResponse
+	var err error
+	if r.plugin.idempotency != nil {
+		var keep bool
+		in.IdempotencyKey, keep = r.plugin.idempotency.broadcastKey(in)
+		resp, err = idempotentCall(ctx, r.plugin.idempotency, in.GetIdempotencyKey(), keep, call)
+	} else {
+		resp, err = call()
 	}
-	resp, err := client.Broadcast(context.Background(), in)
 	if err != nil {
 		return err
 	}
@@ -625,3 +649,208 @@ func (r *rpc) CancelPush(in *v1Client.CancelPushRequest, out *v1Client.CancelPus
 
 	return nil
 }
+
+// Replay feeds a recording of the proxy traffic into the worker pool and reports the responses
+// which differ from the recorded ones.
+func (r *rpc) Replay(in *ReplayRequest, out *ReplayReport) error {
+	r.log.Debug("got replay request", "path", in.Path)
+
+	report, err := r.plugin.replay(context.Background(), in.Path)
+	if err != nil {
+		return err
+	}
+
+	*out = *report
+
+	return nil
+}
+
+// CanaryWeight changes the percentage of the proxy calls routed to the canary pool.
+func (r *rpc) CanaryWeight(in *CanaryWeightRequest, out *CanaryState) error {
+	r.log.Debug("got canary weight request", "weight", in.Weight)
+
+	st, err := r.plugin.setCanaryWeight(in.Weight)
+	if err != nil {
+		return err
+	}
+
+	*out = *st
+
+	return nil
+}
+
+// CanaryPromote replaces the stable pool with the canary pool.
+func (r *rpc) CanaryPromote(_ *CanaryRequest, out *CanaryState) error {
+	r.log.Debug("got canary promote request")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	st, err := r.plugin.promoteCanary(ctx)
+	if err != nil {
+		return err
+	}
+
+	*out = *st
+
+	return nil
+}
+
+// CanaryStatus returns the canary routing state.
+func (r *rpc) CanaryStatus(_ *CanaryRequest, out *CanaryState) error {
+	r.log.Debug("got canary status request")
+
+	st, err := r.plugin.canaryState()
+	if err != nil {
+		return err
+	}
+
+	*out = *st
+
+	return nil
+}
+
+// OccupiedChannels returns the occupied channels matching the pattern.
+func (r *rpc) OccupiedChannels(in *OccupancyRequest, out *OccupiedChannels) error {
+	r.log.Debug("got occupied channels request", "pattern", in.Pattern)
+
+	channels, err := r.plugin.occupancy.list(in.Pattern)
+	if err != nil {
+		return err
+	}
+
+	out.Channels = channels
+
+	return nil
+}
+
+// OccupiedChannelsCount returns the number of the occupied channels matching the pattern.
+func (r *rpc) OccupiedChannelsCount(in *OccupancyRequest, out *OccupiedChannelsCount) error {
+	r.log.Debug("got occupied channels count request", "pattern", in.Pattern)
+
+	channels, err := r.plugin.occupancy.list(in.Pattern)
+	if err != nil {
+		return err
+	}
+
+	out.Count = len(channels)
+
+	return nil
+}
+
+// ChannelOccupancy reports whether the channel is occupied.
+func (r *rpc) ChannelOccupancy(in *ChannelOccupancyRequest, out *ChannelOccupancy) error {
+	r.log.Debug("got channel occupancy request", "channel", in.Channel)
+
+	if in.Channel == "" {
+		return errors.Str("channel should not be empty")
+	}
+
+	out.Channel = in.Channel
+	out.SinceMs, out.Occupied = r.plugin.occupancy.occupied(in.Channel)
+
+	return nil
+}
+
+// AsyncPublish queues the publication, it is sent to Centrifugo in the background and retried on failures.
+func (r *rpc) AsyncPublish(in *v1Client.PublishRequest, out *AsyncResponse) error {
+	r.log.Debug("got async publish request", "channel", in.GetChannel())
+
+	if r.plugin.outbox == nil {
+		return errors.Str("outbox is not configured")
+	}
+
+	if in.GetChannel() == "" {
+		return errors.Str("channel should not be empty")
+	}
+
+	if r.plugin.idempotency != nil {
+		// Centrifugo drops the repeated deliveries of the outbox
+		in.IdempotencyKey, _ = r.plugin.idempotency.publishKey(in)
+	}
+
+	depth, err := r.plugin.outbox.enqueue(&v1Client.Command{Publish: in})
+	if err != nil {
+		return err
+	}
+
+	out.Depth = depth
+
+	return nil
+}
+
+// AsyncBroadcast queues the broadcast, it is sent to Centrifugo in the background and retried on failures.
+func (r *rpc) AsyncBroadcast(in *v1Client.BroadcastRequest, out *AsyncResponse) error {
+	r.log.Debug("got async broadcast request", "channels", in.GetChannels())
+
+	if r.plugin.outbox == nil {
+		return errors.Str("outbox is not configured")
+	}
+
+	if len(in.GetChannels()) == 0 {
+		return errors.Str("channels should not be empty")
+	}
+
+	if r.plugin.idempotency != nil {
+		in.IdempotencyKey, _ = r.plugin.idempotency.broadcastKey(in)
+	}
+
+	depth, err := r.plugin.outbox.enqueue(&v1Client.Command{Broadcast: in})
+	if err != nil {
+		return err
+	}
+
+	out.Depth = depth
+
+	return nil
+}
+
+// GenerateConnectionToken issues a Centrifugo connection token signed with the active key.
+func (r *rpc) GenerateConnectionToken(in *ConnectionTokenRequest, out *Token) error {
+	r.log.Debug("got generate connection token request", "sub", in.Sub)
+
+	if r.plugin.tokens == nil {
+		return errors.Str("tokens are not configured")
+	}
+
+	tok, err := r.plugin.tokens.connection(in)
+	if err != nil {
+		return err
+	}
+
+	*out = *tok
+
+	return nil
+}
+
+// GenerateSubscriptionToken issues a Centrifugo subscription token signed with the active key.
+func (r *rpc) GenerateSubscriptionToken(in *SubscriptionTokenRequest, out *Token) error {
+	r.log.Debug("got generate subscription token request", "sub", in.Sub, "channel", in.Channel)
+
+	if r.plugin.tokens == nil {
+		return errors.Str("tokens are not configured")
+	}
+
+	tok, err := r.plugin.tokens.subscription(in)
+	if err != nil {
+		return err
+	}
+
+	*out = *tok
+
+	return nil
+}
+
+// VerifyToken validates a connection or subscription token against the configured keys. An invalid
+// token is reported with the reason, the error is returned only if the tokens are not configured.
+func (r *rpc) VerifyToken(in *VerifyTokenRequest, out *TokenVerification) error {
+	r.log.Debug("got verify token request", "channel", in.Channel)
+
+	if r.plugin.tokens == nil {
+		return errors.Str("tokens are not configured")
+	}
+
+	*out = *r.plugin.tokens.verify(in)
+
+	return nil
+}
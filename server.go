@@ -0,0 +1,59 @@
+package centrifuge
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// keepalive defaults of the gRPC server, applied to the unset fields
+const (
+	defaultKeepaliveTime    = time.Hour * 2
+	defaultKeepaliveTimeout = time.Second * 20
+	defaultKeepaliveMinTime = time.Minute * 5
+)
+
+// serverOptions returns the proxy gRPC server options, the zero values keep the gRPC defaults.
+func (g *GrpcServer) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+
+	if g == nil {
+		return opts
+	}
+
+	if g.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(g.MaxRecvMsgSize*1024*1024))
+	}
+
+	if g.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(g.MaxSendMsgSize*1024*1024))
+	}
+
+	if g.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(g.MaxConcurrentStreams))
+	}
+
+	if g.ConnectionTimeout > 0 {
+		opts = append(opts, grpc.ConnectionTimeout(g.ConnectionTimeout))
+	}
+
+	if g.Keepalive != nil {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     g.Keepalive.MaxConnectionIdle,
+			MaxConnectionAge:      g.Keepalive.MaxConnectionAge,
+			MaxConnectionAgeGrace: g.Keepalive.MaxConnectionAgeGrace,
+			Time:                  g.Keepalive.Time,
+			Timeout:               g.Keepalive.Timeout,
+		}))
+	}
+
+	if g.KeepaliveEnforcement != nil {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             g.KeepaliveEnforcement.MinTime,
+			PermitWithoutStream: g.KeepaliveEnforcement.PermitWithoutStream,
+		}))
+	}
+
+	return opts
+}
@@ -0,0 +1,60 @@
+package centrifuge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcServerOptions(t *testing.T) {
+	assert.Empty(t, (*GrpcServer)(nil).serverOptions())
+
+	g := &GrpcServer{
+		MaxRecvMsgSize:       10,
+		MaxSendMsgSize:       10,
+		MaxConcurrentStreams: 100,
+		Keepalive:            &Keepalive{},
+		KeepaliveEnforcement: &KeepaliveEnforcement{},
+	}
+	require.NoError(t, g.InitDefaults())
+
+	assert.Len(t, g.serverOptions(), 5)
+	assert.Equal(t, defaultKeepaliveTime, g.Keepalive.Time)
+	assert.Equal(t, defaultKeepaliveTimeout, g.Keepalive.Timeout)
+	assert.Equal(t, defaultKeepaliveMinTime, g.KeepaliveEnforcement.MinTime)
+
+	require.Error(t, (&GrpcServer{MaxRecvMsgSize: -1}).InitDefaults())
+}
+
+func TestGrpcServerMaxRecvMsgSize(t *testing.T) {
+	srv := grpc.NewServer((&GrpcServer{MaxRecvMsgSize: 1}).serverOptions()...)
+	healthv1.RegisterHealthServer(srv, health.NewServer())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	hc := healthv1.NewHealthClient(conn)
+
+	_, err = hc.Check(t.Context(), &healthv1.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	_, err = hc.Check(t.Context(), &healthv1.HealthCheckRequest{Service: strings.Repeat("x", 2*1024*1024)})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,240 @@
+package centrifuge
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RRShadow is set in the environment of the shadow pool workers,
+// so the application can skip side effects while handling mirrored requests.
+const RRShadow string = "RR_CENTRIFUGE_SHADOW"
+
+// shadow comparison results
+const (
+	shadowMatched  string = "matched"
+	shadowDiverged string = "diverged"
+	// the mirrored call was dropped because too many shadow calls were in flight
+	shadowSkipped string = "skipped"
+)
+
+// divergence reasons
+const (
+	divergenceOutcome        string = "outcome"
+	divergenceErrorCode      string = "error_code"
+	divergenceDisconnectCode string = "disconnect_code"
+	divergenceChannels       string = "channels"
+	divergenceInfo           string = "info"
+	divergenceResponse       string = "response"
+)
+
+// shadow mirrors a percentage of the proxy calls to a candidate worker pool. The shadow
+// response is compared with the primary one and discarded, Centrifugo always gets the primary response.
+type shadow struct {
+	log     *slog.Logger
+	metrics *proxyMetrics
+	// exec sends the request to the shadow pool
+	exec       Handler
+	percentage float64
+	types      []string
+	timeout    time.Duration
+	// limits the number of in-flight shadow calls
+	sem chan struct{}
+	// mu guards closed, so no mirror is started once wait is called
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newShadow(cfg *Shadow, exec Handler, metrics *proxyMetrics, log *slog.Logger) *shadow {
+	return &shadow{
+		log:        log,
+		metrics:    metrics,
+		exec:       exec,
+		percentage: cfg.Percentage,
+		types:      cfg.Types,
+		timeout:    cfg.Timeout,
+		sem:        make(chan struct{}, cfg.MaxInFlight),
+	}
+}
+
+// Middleware mirrors the call after the primary pool answered, so the primary latency is not affected.
+func (s *shadow) Middleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (proto.Message, error) {
+		resp, err := next(ctx, req)
+
+		if !s.sampled(req.Type) {
+			return resp, err
+		}
+
+		select {
+		case s.sem <- struct{}{}:
+		default:
+			s.observe(req.Type, shadowSkipped)
+			return resp, err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			<-s.sem
+
+			return resp, err
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		// the outer middleware may change the request and the response once returned, and gRPC
+		// marshals the response, the mirror works on its own copies
+		sreq := *req
+		sreq.Message = proto.Clone(req.Message)
+		sreq.Meta = req.Meta.Copy()
+		presp := proto.Clone(resp)
+
+		go func() {
+			defer func() {
+				<-s.sem
+				s.wg.Done()
+			}()
+
+			s.mirror(context.WithoutCancel(ctx), &sreq, presp, err)
+		}()
+
+		return resp, err
+	}
+}
+
+func (s *shadow) sampled(typ string) bool {
+	if s.percentage <= 0 || !slices.Contains(s.types, typ) {
+		return false
+	}
+
+	return s.percentage >= 100 || rand.Float64()*100 < s.percentage //nolint:gosec
+}
+
+func (s *shadow) mirror(ctx context.Context, req *Request, resp proto.Message, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	sresp, serr := s.exec(ctx, req)
+
+	reasons := diverged(resp, err, sresp, serr)
+	if len(reasons) == 0 {
+		s.observe(req.Type, shadowMatched)
+		return
+	}
+
+	s.observe(req.Type, shadowDiverged)
+	if s.metrics != nil {
+		for _, r := range reasons {
+			s.metrics.shadowDivergences.WithLabelValues(req.Type, r).Inc()
+		}
+	}
+
+	args := []any{
+		"request_id", req.ID,
+		"type", req.Type,
+		"reasons", reasons,
+		"primary", outcome(resp, err),
+		"shadow", outcome(sresp, serr),
+	}
+	if serr != nil {
+		args = append(args, "shadow_error", serr.Error())
+	}
+
+	s.log.Warn("shadow proxy response diverged", args...)
+}
+
+func (s *shadow) observe(typ, result string) {
+	if s.metrics != nil {
+		s.metrics.shadowRequests.WithLabelValues(typ, result).Inc()
+	}
+}
+
+// wait stops mirroring and blocks until the in-flight shadow calls are finished.
+func (s *shadow) wait() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// diverged compares the primary and shadow results and returns the divergence reasons, nil if they match.
+func diverged(primary proto.Message, perr error, shadowed proto.Message, serr error) []string {
+	if outcome(primary, perr) != outcome(shadowed, serr) {
+		return []string{divergenceOutcome}
+	}
+
+	// both failed, there is nothing to compare
+	if perr != nil || serr != nil || primary == nil || shadowed == nil {
+		return nil
+	}
+
+	var reasons []string
+
+	if p, ok := primary.(errorResponse); ok {
+		if p.GetError().GetCode() != shadowed.(errorResponse).GetError().GetCode() {
+			reasons = append(reasons, divergenceErrorCode)
+		}
+	}
+
+	if p, ok := primary.(disconnectResponse); ok {
+		if p.GetDisconnect().GetCode() != shadowed.(disconnectResponse).GetDisconnect().GetCode() {
+			reasons = append(reasons, divergenceDisconnectCode)
+		}
+	}
+
+	pres, sres := responseResult(primary), responseResult(shadowed)
+	if !fieldsEqual(pres, sres, "channels") {
+		reasons = append(reasons, divergenceChannels)
+	}
+
+	if !fieldsEqual(pres, sres, "info", "b64info") {
+		reasons = append(reasons, divergenceInfo)
+	}
+
+	if len(reasons) == 0 && !proto.Equal(primary, shadowed) {
+		reasons = append(reasons, divergenceResponse)
+	}
+
+	return reasons
+}
+
+// responseResult returns the `result` field of a Centrifugo proxy response, nil if not set.
+func responseResult(resp proto.Message) protoreflect.Message {
+	m := resp.ProtoReflect()
+
+	fd := m.Descriptor().Fields().ByName("result")
+	if fd == nil || fd.Message() == nil || !m.Has(fd) {
+		return nil
+	}
+
+	return m.Get(fd).Message()
+}
+
+// fieldsEqual compares the named fields of two results of the same type.
+func fieldsEqual(a, b protoreflect.Message, names ...protoreflect.Name) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	for _, n := range names {
+		fd := a.Descriptor().Fields().ByName(n)
+		if fd == nil {
+			continue
+		}
+
+		if !a.Get(fd).Equal(b.Get(fd)) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,195 @@
+package centrifuge
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	centrifugov1 "github.com/roadrunner-server/api-go/v6/centrifugo/proxy/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func respond(resp proto.Message, err error) Handler {
+	return func(context.Context, *Request) (proto.Message, error) {
+		return resp, err
+	}
+}
+
+func TestShadowDiverged(t *testing.T) {
+	allowed := &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1", Channels: []string{"news"}, Info: []byte(`{"a":1}`)}}
+	denied := &centrifugov1.ConnectResponse{Error: &centrifugov1.Error{Code: 103, Message: "permission denied"}}
+	failed := errors.New("worker empty response")
+
+	tests := []struct {
+		name    string
+		primary proto.Message
+		perr    error
+		shadow  proto.Message
+		serr    error
+		reasons []string
+	}{
+		{"equal", allowed, nil, proto.Clone(allowed), nil, nil},
+		{"allowed vs denied", allowed, nil, denied, nil, []string{divergenceOutcome}},
+		{"shadow failed", allowed, nil, nil, failed, []string{divergenceOutcome}},
+		{"both failed", nil, failed, nil, failed, nil},
+		{
+			"error code", denied, nil,
+			&centrifugov1.ConnectResponse{Error: &centrifugov1.Error{Code: 104, Message: "permission denied"}}, nil,
+			[]string{divergenceErrorCode},
+		},
+		{
+			"channels", allowed, nil,
+			&centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1", Channels: []string{"news", "chat"}, Info: []byte(`{"a":1}`)}}, nil,
+			[]string{divergenceChannels},
+		},
+		{
+			"info", allowed, nil,
+			&centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1", Channels: []string{"news"}, Info: []byte(`{"a":2}`)}}, nil,
+			[]string{divergenceInfo},
+		},
+		{
+			"response", allowed, nil,
+			&centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u2", Channels: []string{"news"}, Info: []byte(`{"a":1}`)}}, nil,
+			[]string{divergenceResponse},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.reasons, diverged(tt.primary, tt.perr, tt.shadow, tt.serr))
+		})
+	}
+}
+
+func TestShadowMiddleware(t *testing.T) {
+	primary := &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1"}}
+	denied := &centrifugov1.ConnectResponse{Error: &centrifugov1.Error{Code: 103}}
+
+	metrics := newProxyMetrics()
+	s := newShadow(&Shadow{Percentage: 100, Types: []string{connectType}, Timeout: time.Second, MaxInFlight: 10}, respond(denied, nil), metrics, testLogger())
+
+	h := s.Middleware(respond(primary, nil))
+
+	// the primary response is returned regardless of the shadow one
+	resp, err := h(t.Context(), &Request{ID: "1", Type: connectType, Message: &centrifugov1.ConnectRequest{}})
+	require.NoError(t, err)
+	assert.Same(t, primary, resp)
+
+	// not a mirrored type
+	_, err = h(t.Context(), &Request{ID: "2", Type: rpcType, Message: &centrifugov1.RPCRequest{}})
+	require.NoError(t, err)
+
+	s.wait()
+
+	assert.Equal(t, 1, collectCount(t, metrics.shadowRequests))
+	assert.InDelta(t, 1, counterValue(t, metrics.shadowRequests.WithLabelValues(connectType, shadowDiverged)), 0)
+	assert.InDelta(t, 1, counterValue(t, metrics.shadowDivergences.WithLabelValues(connectType, divergenceOutcome)), 0)
+}
+
+func TestShadowSampling(t *testing.T) {
+	s := &shadow{percentage: 0, types: []string{connectType}}
+	assert.False(t, s.sampled(connectType))
+
+	s.percentage = 100
+	assert.True(t, s.sampled(connectType))
+	assert.False(t, s.sampled(publishType))
+}
+
+func TestShadowMaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	slow := func(context.Context, *Request) (proto.Message, error) {
+		<-release
+		return &centrifugov1.ConnectResponse{}, nil
+	}
+
+	metrics := newProxyMetrics()
+	s := newShadow(&Shadow{Percentage: 100, Types: []string{connectType}, Timeout: time.Second, MaxInFlight: 1}, slow, metrics, testLogger())
+	h := s.Middleware(respond(&centrifugov1.ConnectResponse{}, nil))
+
+	for range 3 {
+		_, err := h(t.Context(), &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{}})
+		require.NoError(t, err)
+	}
+
+	close(release)
+	s.wait()
+
+	assert.InDelta(t, 1, counterValue(t, metrics.shadowRequests.WithLabelValues(connectType, shadowMatched)), 0)
+	assert.InDelta(t, 2, counterValue(t, metrics.shadowRequests.WithLabelValues(connectType, shadowSkipped)), 0)
+}
+
+func TestShadowStopped(t *testing.T) {
+	var calls atomic.Int32
+	exec := func(context.Context, *Request) (proto.Message, error) {
+		calls.Add(1)
+		return &centrifugov1.ConnectResponse{}, nil
+	}
+
+	s := newShadow(&Shadow{Percentage: 100, Types: []string{connectType}, Timeout: time.Second, MaxInFlight: 1}, exec, newProxyMetrics(), testLogger())
+	h := s.Middleware(respond(&centrifugov1.ConnectResponse{}, nil))
+	s.wait()
+
+	// the primary call is served, it is not mirrored after the stop
+	for range 2 {
+		_, err := h(t.Context(), &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{}})
+		require.NoError(t, err)
+	}
+
+	assert.Zero(t, calls.Load())
+	// the in-flight slot was released
+	assert.Empty(t, s.sem)
+}
+
+func TestShadowResponseCopy(t *testing.T) {
+	release := make(chan struct{})
+	exec := func(context.Context, *Request) (proto.Message, error) {
+		<-release
+		return &centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1"}}, nil
+	}
+
+	metrics := newProxyMetrics()
+	s := newShadow(&Shadow{Percentage: 100, Types: []string{connectType}, Timeout: time.Second, MaxInFlight: 1}, exec, metrics, testLogger())
+	h := s.Middleware(respond(&centrifugov1.ConnectResponse{Result: &centrifugov1.ConnectResult{User: "u1"}}, nil))
+
+	resp, err := h(t.Context(), &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{}})
+	require.NoError(t, err)
+
+	// an outer middleware changes the returned response while the mirror is in flight
+	resp.(*centrifugov1.ConnectResponse).Result.User = "changed"
+	close(release)
+	s.wait()
+
+	assert.InDelta(t, 1, counterValue(t, metrics.shadowRequests.WithLabelValues(connectType, shadowMatched)), 0)
+}
+
+func TestShadowRequestCopy(t *testing.T) {
+	release := make(chan struct{})
+	mirrored := make(chan *Request, 1)
+	exec := func(_ context.Context, req *Request) (proto.Message, error) {
+		<-release
+		mirrored <- req
+		return &centrifugov1.ConnectResponse{}, nil
+	}
+
+	s := newShadow(&Shadow{Percentage: 100, Types: []string{connectType}, Timeout: time.Second, MaxInFlight: 1}, exec, newProxyMetrics(), testLogger())
+	h := s.Middleware(respond(&centrifugov1.ConnectResponse{}, nil))
+
+	req := &Request{Type: connectType, Message: &centrifugov1.ConnectRequest{Client: "c1"}, Meta: metadata.Pairs("x-id", "1")}
+	_, err := h(t.Context(), req)
+	require.NoError(t, err)
+
+	// the live request is changed while the mirror is in flight
+	req.Message.(*centrifugov1.ConnectRequest).Client = "changed"
+	req.Meta.Set("x-id", "changed")
+	close(release)
+	s.wait()
+
+	sreq := <-mirrored
+	assert.Equal(t, "c1", sreq.Message.(*centrifugov1.ConnectRequest).GetClient())
+	assert.Equal(t, []string{"1"}, sreq.Meta.Get("x-id"))
+}
@@ -0,0 +1,421 @@
+package centrifuge
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for the HS256, RS256 and ES256 tokens
+	_ "crypto/sha512" // SHA-384 and SHA-512 for the other tokens
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+func tokenAlgorithms() []string {
+	return []string{
+		"HS256", "HS384", "HS512",
+		"RS256", "RS384", "RS512",
+		"ES256", "ES384", "ES512",
+	}
+}
+
+// tokenKey is a loaded key, either an HMAC secret or an RSA or ECDSA key. The keys loaded
+// from a public key only verify the tokens.
+type tokenKey struct {
+	kid    string
+	alg    string
+	hash   crypto.Hash
+	secret []byte
+	signer crypto.Signer
+	public crypto.PublicKey
+}
+
+func loadTokenKey(cfg *TokenKey) (*tokenKey, error) {
+	data := []byte(cfg.Secret)
+	if cfg.File != "" {
+		var err error
+		data, err = os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	k := &tokenKey{kid: cfg.ID, alg: cfg.Algorithm}
+
+	switch cfg.Algorithm[2:] {
+	case "256":
+		k.hash = crypto.SHA256
+	case "384":
+		k.hash = crypto.SHA384
+	default:
+		k.hash = crypto.SHA512
+	}
+
+	if cfg.Algorithm[:2] == "HS" {
+		if cfg.File != "" {
+			// the trailing newline of the secret file is not a part of the secret
+			data = bytes.TrimRight(data, "\r\n")
+		}
+
+		if len(data) == 0 {
+			return nil, errors.Errorf("token key '%s' has an empty secret", cfg.ID)
+		}
+
+		k.secret = data
+
+		return k, nil
+	}
+
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, errors.Errorf("token key '%s': %v", cfg.ID, err)
+	}
+
+	// a public key only verifies the tokens
+	k.public = key
+	if signer, ok := key.(crypto.Signer); ok {
+		k.signer = signer
+		k.public = signer.Public()
+	}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		if cfg.Algorithm[:2] != "RS" {
+			return nil, errors.Errorf("token key '%s': %s needs an ECDSA key, got an RSA key", cfg.ID, cfg.Algorithm)
+		}
+	case *ecdsa.PublicKey:
+		if cfg.Algorithm[:2] != "ES" {
+			return nil, errors.Errorf("token key '%s': %s needs an RSA key, got an ECDSA key", cfg.ID, cfg.Algorithm)
+		}
+
+		if pub.Curve != algCurve(cfg.Algorithm) {
+			return nil, errors.Errorf("token key '%s': %s needs a %s key, got %s", cfg.ID, cfg.Algorithm, algCurve(cfg.Algorithm).Params().Name, pub.Curve.Params().Name)
+		}
+	default:
+		return nil, errors.Errorf("token key '%s': unsupported %s key %T", cfg.ID, cfg.Algorithm, k.public)
+	}
+
+	return k, nil
+}
+
+// algCurve is the curve of the ECDSA algorithm, ES512 uses P-521.
+func algCurve(alg string) elliptic.Curve {
+	switch alg {
+	case "ES256":
+		return elliptic.P256()
+	case "ES384":
+		return elliptic.P384()
+	default:
+		return elliptic.P521()
+	}
+}
+
+// parseKey parses a PEM encoded PKCS #8, PKCS #1 (RSA) or SEC 1 (EC) private key, or a PKIX or PKCS #1
+// public key, or the public key of a certificate.
+func parseKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Str("no PEM encoded key found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+
+	return nil, errors.Errorf("unsupported key in the PEM block '%s'", block.Type)
+}
+
+// sign returns the JWS signature of the data.
+func (k *tokenKey) sign(data []byte) ([]byte, error) {
+	if k.secret != nil {
+		mac := hmac.New(k.hash.New, k.secret)
+		_, _ = mac.Write(data)
+
+		return mac.Sum(nil), nil
+	}
+
+	h := k.hash.New()
+	_, _ = h.Write(data)
+	digest := h.Sum(nil)
+
+	switch key := k.signer.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, k.hash, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// JWS uses the fixed size R || S encoding instead of ASN.1
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+
+		return sig, nil
+	default:
+		return nil, errors.Errorf("unsupported signing key %T", k.signer)
+	}
+}
+
+// tokens issues and verifies the Centrifugo connection and subscription JWTs. The new tokens
+// are signed with the active key and carry its kid, so the keys can be rotated without
+// invalidating the tokens already issued.
+type tokens struct {
+	keys     map[string]*tokenKey
+	active   *tokenKey
+	ttl      time.Duration
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func newTokens(cfg *Tokens) (*tokens, error) {
+	t := &tokens{
+		keys:     make(map[string]*tokenKey, len(cfg.Keys)),
+		ttl:      cfg.TTL,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		now:      time.Now,
+	}
+
+	for _, kc := range cfg.Keys {
+		k, err := loadTokenKey(kc)
+		if err != nil {
+			return nil, err
+		}
+
+		t.keys[k.kid] = k
+	}
+
+	t.active = t.keys[cfg.ActiveKey]
+	if t.active.secret == nil && t.active.signer == nil {
+		return nil, errors.Errorf("active token key '%s' is a public key and can not sign", cfg.ActiveKey)
+	}
+
+	return t, nil
+}
+
+// registeredClaims are the claims set on every token.
+type registeredClaims struct {
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp,omitempty"`
+	Iat int64  `json:"iat"`
+	Iss string `json:"iss,omitempty"`
+	Aud string `json:"aud,omitempty"`
+}
+
+type connectionClaims struct {
+	registeredClaims
+	Info     json.RawMessage                 `json:"info,omitempty"`
+	B64Info  string                          `json:"b64info,omitempty"`
+	Channels []string                        `json:"channels,omitempty"`
+	Subs     map[string]*SubscriptionOptions `json:"subs,omitempty"`
+	Meta     json.RawMessage                 `json:"meta,omitempty"`
+}
+
+type subscriptionClaims struct {
+	registeredClaims
+	Channel string          `json:"channel"`
+	Info    json.RawMessage `json:"info,omitempty"`
+	B64Info string          `json:"b64info,omitempty"`
+}
+
+func (t *tokens) connection(in *ConnectionTokenRequest) (*Token, error) {
+	err := checkBase64("b64info", in.B64Info)
+	if err != nil {
+		return nil, err
+	}
+
+	for ch, opts := range in.Subs {
+		if ch == "" {
+			return nil, errors.Str("subs channel should not be empty")
+		}
+
+		if opts == nil {
+			continue
+		}
+
+		err = checkBase64("subs b64info", opts.B64Info)
+		if err == nil {
+			err = checkBase64("subs b64data", opts.B64Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	claims := &connectionClaims{
+		registeredClaims: t.registered(in.Sub, in.TTL),
+		Info:             in.Info,
+		B64Info:          in.B64Info,
+		Channels:         in.Channels,
+		Subs:             in.Subs,
+		Meta:             in.Meta,
+	}
+
+	return t.issue(claims, claims.Exp)
+}
+
+func (t *tokens) subscription(in *SubscriptionTokenRequest) (*Token, error) {
+	if in.Channel == "" {
+		return nil, errors.Str("channel should not be empty")
+	}
+
+	err := checkBase64("b64info", in.B64Info)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &subscriptionClaims{
+		registeredClaims: t.registered(in.Sub, in.TTL),
+		Channel:          in.Channel,
+		Info:             in.Info,
+		B64Info:          in.B64Info,
+	}
+
+	return t.issue(claims, claims.Exp)
+}
+
+// registered fills the common claims, a zero ttl uses the configured one and a negative ttl
+// issues a token without expiration.
+func (t *tokens) registered(sub string, ttl int64) registeredClaims {
+	now := t.now()
+
+	rc := registeredClaims{
+		Sub: sub,
+		Iat: now.Unix(),
+		Iss: t.issuer,
+		Aud: t.audience,
+	}
+
+	switch {
+	case ttl == 0:
+		rc.Exp = now.Add(t.ttl).Unix()
+	case ttl > 0:
+		rc.Exp = now.Add(time.Duration(ttl) * time.Second).Unix()
+	}
+
+	return rc
+}
+
+// issue encodes and signs the claims with the active key.
+func (t *tokens) issue(claims any, exp int64) (*Token, error) {
+	header := struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+		Kid string `json:"kid,omitempty"`
+	}{Alg: t.active.alg, Typ: "JWT", Kid: t.active.kid}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, errors.Errorf("failed to encode the token claims: %v", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(base64.RawURLEncoding.EncodeToString(h))
+	sb.WriteByte('.')
+	sb.WriteString(base64.RawURLEncoding.EncodeToString(payload))
+
+	sig, err := t.active.sign([]byte(sb.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	sb.WriteByte('.')
+	sb.WriteString(base64.RawURLEncoding.EncodeToString(sig))
+
+	return &Token{Token: sb.String(), Kid: t.active.kid, ExpiresAt: exp}, nil
+}
+
+func checkBase64(field, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+		return errors.Errorf("%s should be base64 encoded: %v", field, err)
+	}
+
+	return nil
+}
+
+// SubscriptionOptions are the options of a server-side subscription in the subs claim.
+type SubscriptionOptions struct {
+	Info    json.RawMessage `json:"info,omitempty"`
+	B64Info string          `json:"b64info,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	B64Data string          `json:"b64data,omitempty"`
+}
+
+// ConnectionTokenRequest holds the claims of a connection token.
+type ConnectionTokenRequest struct {
+	// Sub is the user ID, empty for an anonymous user.
+	Sub string `json:"sub"`
+	// TTL is the token lifetime in seconds, the configured one if 0. A negative TTL issues a token without expiration.
+	TTL     int64           `json:"ttl"`
+	Info    json.RawMessage `json:"info,omitempty"`
+	B64Info string          `json:"b64info,omitempty"`
+	// Channels are the server-side subscriptions.
+	Channels []string `json:"channels,omitempty"`
+	// Subs are the server-side subscriptions with options, keyed by the channel.
+	Subs map[string]*SubscriptionOptions `json:"subs,omitempty"`
+	// Meta is attached to the connection and never sent to the client.
+	Meta json.RawMessage `json:"meta,omitempty"`
+}
+
+// SubscriptionTokenRequest holds the claims of a subscription token.
+type SubscriptionTokenRequest struct {
+	// Sub is the user ID, it should match the user of the connection.
+	Sub     string `json:"sub"`
+	Channel string `json:"channel"`
+	// TTL is the token lifetime in seconds, the configured one if 0. A negative TTL issues a token without expiration.
+	TTL     int64           `json:"ttl"`
+	Info    json.RawMessage `json:"info,omitempty"`
+	B64Info string          `json:"b64info,omitempty"`
+}
+
+// Token is a signed JWT.
+type Token struct {
+	Token string `json:"token"`
+	// Kid is the ID of the signing key, empty if the key has none.
+	Kid string `json:"kid"`
+	// ExpiresAt is the exp claim in Unix seconds, 0 if the token does not expire.
+	ExpiresAt int64 `json:"expires_at"`
+}
@@ -0,0 +1,207 @@
+package centrifuge
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, key any) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	return file
+}
+
+// splitToken returns the decoded header and claims, the signed input and the signature.
+func splitToken(t *testing.T, token string) (map[string]any, map[string]any, []byte, []byte) {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	decode := func(s string) map[string]any {
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		require.NoError(t, err)
+
+		m := make(map[string]any)
+		require.NoError(t, json.Unmarshal(data, &m))
+
+		return m
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+
+	return decode(parts[0]), decode(parts[1]), []byte(parts[0] + "." + parts[1]), sig
+}
+
+func TestConnectionTokenHMAC(t *testing.T) {
+	cfg := &Tokens{Keys: []*TokenKey{{Algorithm: "HS256", Secret: "secret"}}, Issuer: "rr"}
+	require.NoError(t, cfg.InitDefaults())
+
+	tk, err := newTokens(cfg)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	tk.now = func() time.Time { return now }
+
+	tok, err := tk.connection(&ConnectionTokenRequest{
+		Sub:      "42",
+		Info:     json.RawMessage(`{"name":"alice"}`),
+		Channels: []string{"news"},
+		Subs:     map[string]*SubscriptionOptions{"personal:42": {Data: json.RawMessage(`{"a":1}`)}},
+		Meta:     json.RawMessage(`{"tenant":"t1"}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour).Unix(), tok.ExpiresAt)
+	assert.Empty(t, tok.Kid)
+
+	header, claims, signed, sig := splitToken(t, tok.Token)
+	assert.Equal(t, map[string]any{"alg": "HS256", "typ": "JWT"}, header)
+	assert.Equal(t, "42", claims["sub"])
+	assert.Equal(t, "rr", claims["iss"])
+	assert.InDelta(t, float64(now.Add(time.Hour).Unix()), claims["exp"], 0)
+	assert.Equal(t, map[string]any{"name": "alice"}, claims["info"])
+	assert.Equal(t, []any{"news"}, claims["channels"])
+	assert.Equal(t, map[string]any{"personal:42": map[string]any{"data": map[string]any{"a": 1.0}}}, claims["subs"])
+	assert.Equal(t, map[string]any{"tenant": "t1"}, claims["meta"])
+	assert.NotContains(t, claims, "aud")
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	_, _ = mac.Write(signed)
+	assert.Equal(t, mac.Sum(nil), sig)
+
+	// no expiration
+	tok, err = tk.connection(&ConnectionTokenRequest{Sub: "42", TTL: -1})
+	require.NoError(t, err)
+	assert.Zero(t, tok.ExpiresAt)
+	_, claims, _, _ = splitToken(t, tok.Token)
+	assert.NotContains(t, claims, "exp")
+}
+
+func TestSubscriptionTokenRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	cfg := &Tokens{Keys: []*TokenKey{{ID: "rsa-1", Algorithm: "RS256", File: writeKey(t, key)}}}
+	require.NoError(t, cfg.InitDefaults())
+
+	tk, err := newTokens(cfg)
+	require.NoError(t, err)
+
+	tok, err := tk.subscription(&SubscriptionTokenRequest{Sub: "42", Channel: "chat:1", TTL: 60})
+	require.NoError(t, err)
+	assert.Equal(t, "rsa-1", tok.Kid)
+
+	header, claims, signed, sig := splitToken(t, tok.Token)
+	assert.Equal(t, "RS256", header["alg"])
+	assert.Equal(t, "rsa-1", header["kid"])
+	assert.Equal(t, "chat:1", claims["channel"])
+
+	digest := sha256.Sum256(signed)
+	require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+
+	_, err = tk.subscription(&SubscriptionTokenRequest{Sub: "42"})
+	require.Error(t, err)
+}
+
+func TestTokenECDSARotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	cfg := &Tokens{
+		Keys: []*TokenKey{
+			{ID: "k1", Algorithm: "ES256", File: writeKey(t, oldKey)},
+			{ID: "k2", Algorithm: "ES256", File: writeKey(t, newKey)},
+		},
+		ActiveKey: "k2",
+	}
+	require.NoError(t, cfg.InitDefaults())
+
+	tk, err := newTokens(cfg)
+	require.NoError(t, err)
+
+	tok, err := tk.connection(&ConnectionTokenRequest{Sub: "42"})
+	require.NoError(t, err)
+	assert.Equal(t, "k2", tok.Kid)
+
+	_, _, signed, sig := splitToken(t, tok.Token)
+	require.Len(t, sig, 64)
+
+	digest := sha256.Sum256(signed)
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	assert.True(t, ecdsa.Verify(&newKey.PublicKey, digest[:], r, s))
+	assert.False(t, ecdsa.Verify(&oldKey.PublicKey, digest[:], r, s))
+}
+
+func TestTokenKeyErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// the curve does not match the algorithm
+	_, err = loadTokenKey(&TokenKey{Algorithm: "ES384", File: writeKey(t, ecKey)})
+	require.Error(t, err)
+
+	// an EC key for an RSA algorithm
+	_, err = loadTokenKey(&TokenKey{Algorithm: "RS256", File: writeKey(t, ecKey)})
+	require.Error(t, err)
+
+	_, err = loadTokenKey(&TokenKey{Algorithm: "RS256", Secret: "not a pem"})
+	require.Error(t, err)
+
+	// the trailing newline of a secret file is dropped
+	file := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(file, []byte("secret\n"), 0o600))
+
+	k, err := loadTokenKey(&TokenKey{Algorithm: "HS512", File: file})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), k.secret)
+}
+
+func TestTokenClaimsValidation(t *testing.T) {
+	cfg := &Tokens{Keys: []*TokenKey{{Algorithm: "HS256", Secret: "secret"}}}
+	require.NoError(t, cfg.InitDefaults())
+
+	tk, err := newTokens(cfg)
+	require.NoError(t, err)
+
+	_, err = tk.connection(&ConnectionTokenRequest{Sub: "42", B64Info: "not base64!"})
+	require.Error(t, err)
+
+	_, err = tk.connection(&ConnectionTokenRequest{Sub: "42", Info: json.RawMessage(`{broken`)})
+	require.Error(t, err)
+
+	_, err = tk.connection(&ConnectionTokenRequest{Sub: "42", Subs: map[string]*SubscriptionOptions{"news": {B64Data: "%%"}}})
+	require.Error(t, err)
+}
+
+func TestTokensRPCNotConfigured(t *testing.T) {
+	r := &rpc{plugin: &Plugin{}, log: testLogger()}
+	require.Error(t, r.GenerateConnectionToken(&ConnectionTokenRequest{Sub: "42"}, &Token{}))
+	require.Error(t, r.GenerateSubscriptionToken(&SubscriptionTokenRequest{Sub: "42", Channel: "news"}, &Token{}))
+}
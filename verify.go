@@ -0,0 +1,241 @@
+package centrifuge
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// token verification failure reasons
+const (
+	// the token is not a JWT or its header or claims are not valid JSON
+	tokenMalformed string = "malformed"
+	// the alg header is not supported or does not match the key
+	tokenUnsupportedAlgorithm string = "unsupported_algorithm"
+	// no configured key has the kid of the token
+	tokenUnknownKey       string = "unknown_key"
+	tokenInvalidSignature string = "invalid_signature"
+	tokenExpired          string = "expired"
+	tokenNotYetValid      string = "not_yet_valid"
+	tokenIssuerMismatch   string = "issuer_mismatch"
+	tokenAudienceMismatch string = "audience_mismatch"
+	// the token is not a subscription token for the requested channel
+	tokenChannelMismatch string = "channel_mismatch"
+)
+
+// token types, by the channel claim
+const (
+	connectionToken   string = "connection"
+	subscriptionToken string = "subscription"
+)
+
+// verifiedClaims are the claims checked by the verification.
+type verifiedClaims struct {
+	Sub     string          `json:"sub"`
+	Exp     int64           `json:"exp"`
+	Nbf     int64           `json:"nbf"`
+	Iss     string          `json:"iss"`
+	Aud     json.RawMessage `json:"aud"`
+	Channel string          `json:"channel"`
+}
+
+// verify checks the signature, the time claims, the issuer and the audience of the token. The claims
+// are returned once the signature is valid, also for an expired token, the invalid tokens are reported
+// in the result and never as an error.
+func (t *tokens) verify(in *VerifyTokenRequest) *TokenVerification {
+	res := &TokenVerification{}
+
+	parts := strings.Split(in.Token, ".")
+	if len(parts) != 3 {
+		return res.fail(tokenMalformed)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if !decodeSegment(parts[0], &header) {
+		return res.fail(tokenMalformed)
+	}
+
+	res.Alg, res.Kid = header.Alg, header.Kid
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return res.fail(tokenMalformed)
+	}
+
+	claims := &verifiedClaims{}
+	if json.Unmarshal(payload, claims) != nil {
+		return res.fail(tokenMalformed)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return res.fail(tokenMalformed)
+	}
+
+	keys := t.verificationKeys(header.Kid)
+	if len(keys) == 0 {
+		return res.fail(tokenUnknownKey)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	matched, verified := false, false
+	for _, k := range keys {
+		if k.alg != header.Alg {
+			continue
+		}
+
+		matched = true
+		if k.verify(signed, sig) {
+			verified = true
+			break
+		}
+	}
+
+	switch {
+	case !matched:
+		return res.fail(tokenUnsupportedAlgorithm)
+	case !verified:
+		return res.fail(tokenInvalidSignature)
+	}
+
+	res.Claims = payload
+	res.Sub = claims.Sub
+	res.ExpiresAt = claims.Exp
+	res.Channel = claims.Channel
+	res.Type = connectionToken
+	if claims.Channel != "" {
+		res.Type = subscriptionToken
+	}
+
+	now := t.now().Unix()
+
+	switch {
+	case claims.Exp > 0 && now >= claims.Exp:
+		return res.fail(tokenExpired)
+	case claims.Nbf > 0 && now < claims.Nbf:
+		return res.fail(tokenNotYetValid)
+	case t.issuer != "" && claims.Iss != t.issuer:
+		return res.fail(tokenIssuerMismatch)
+	case t.audience != "" && !hasAudience(claims.Aud, t.audience):
+		return res.fail(tokenAudienceMismatch)
+	case in.Channel != "" && claims.Channel != in.Channel:
+		return res.fail(tokenChannelMismatch)
+	}
+
+	res.Valid = true
+
+	return res
+}
+
+// verificationKeys returns the key with the kid, or every key for a token without one.
+func (t *tokens) verificationKeys(kid string) []*tokenKey {
+	if kid != "" {
+		if k, ok := t.keys[kid]; ok {
+			return []*tokenKey{k}
+		}
+
+		return nil
+	}
+
+	keys := make([]*tokenKey, 0, len(t.keys))
+	for _, k := range t.keys {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// verify checks the JWS signature of the data.
+func (k *tokenKey) verify(data, sig []byte) bool {
+	if k.secret != nil {
+		mac := hmac.New(k.hash.New, k.secret)
+		_, _ = mac.Write(data)
+
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+
+	h := k.hash.New()
+	_, _ = h.Write(data)
+	digest := h.Sum(nil)
+
+	switch key := k.public.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, k.hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(seg string, v any) bool {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, v) == nil
+}
+
+// hasAudience reports whether the aud claim, a string or a list of strings, holds the audience.
+func hasAudience(aud json.RawMessage, audience string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == audience
+	}
+
+	var many []string
+	if json.Unmarshal(aud, &many) == nil {
+		return slices.Contains(many, audience)
+	}
+
+	return false
+}
+
+func (v *TokenVerification) fail(reason string) *TokenVerification {
+	v.Valid = false
+	v.Reason = reason
+
+	return v
+}
+
+// VerifyTokenRequest holds the token to verify.
+type VerifyTokenRequest struct {
+	Token string `json:"token"`
+	// Channel requires a subscription token for the channel, any token is accepted if empty.
+	Channel string `json:"channel,omitempty"`
+}
+
+// TokenVerification is the result of the token verification.
+type TokenVerification struct {
+	Valid bool `json:"valid"`
+	// Reason is the failure reason, empty for a valid token: malformed, unsupported_algorithm, unknown_key,
+	// invalid_signature, expired, not_yet_valid, issuer_mismatch, audience_mismatch or channel_mismatch.
+	Reason string `json:"reason,omitempty"`
+	Alg    string `json:"alg,omitempty"`
+	Kid    string `json:"kid,omitempty"`
+	// The fields below are set once the signature is verified. Type is connection or subscription.
+	Type    string `json:"type,omitempty"`
+	Sub     string `json:"sub"`
+	Channel string `json:"channel,omitempty"`
+	// ExpiresAt is the exp claim in Unix seconds, 0 if the token does not expire.
+	ExpiresAt int64 `json:"expires_at"`
+	// Claims are the decoded claims.
+	Claims json.RawMessage `json:"claims,omitempty"`
+}
@@ -0,0 +1,213 @@
+package centrifuge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePublicKey(t *testing.T, key any) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "key.pub")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	return file
+}
+
+func testTokens(t *testing.T, cfg *Tokens) *tokens {
+	t.Helper()
+
+	require.NoError(t, cfg.InitDefaults())
+
+	tk, err := newTokens(cfg)
+	require.NoError(t, err)
+
+	return tk
+}
+
+func TestVerifyToken(t *testing.T) {
+	tk := testTokens(t, &Tokens{
+		Keys:     []*TokenKey{{ID: "k1", Algorithm: "HS256", Secret: "secret"}},
+		Issuer:   "rr",
+		Audience: "centrifugo",
+	})
+
+	now := time.Now()
+	tk.now = func() time.Time { return now }
+
+	tok, err := tk.connection(&ConnectionTokenRequest{Sub: "42", Info: json.RawMessage(`{"name":"alice"}`)})
+	require.NoError(t, err)
+
+	res := tk.verify(&VerifyTokenRequest{Token: tok.Token})
+	assert.True(t, res.Valid)
+	assert.Empty(t, res.Reason)
+	assert.Equal(t, "HS256", res.Alg)
+	assert.Equal(t, "k1", res.Kid)
+	assert.Equal(t, connectionToken, res.Type)
+	assert.Equal(t, "42", res.Sub)
+	assert.Equal(t, tok.ExpiresAt, res.ExpiresAt)
+
+	claims := make(map[string]any)
+	require.NoError(t, json.Unmarshal(res.Claims, &claims))
+	assert.Equal(t, map[string]any{"name": "alice"}, claims["info"])
+
+	// the claims of an expired token are still reported
+	now = now.Add(time.Hour * 2)
+	res = tk.verify(&VerifyTokenRequest{Token: tok.Token})
+	assert.False(t, res.Valid)
+	assert.Equal(t, tokenExpired, res.Reason)
+	assert.Equal(t, "42", res.Sub)
+	assert.NotEmpty(t, res.Claims)
+}
+
+func TestVerifyTokenFailures(t *testing.T) {
+	tk := testTokens(t, &Tokens{Keys: []*TokenKey{{ID: "k1", Algorithm: "HS256", Secret: "secret"}}, Issuer: "rr"})
+
+	tok, err := tk.subscription(&SubscriptionTokenRequest{Sub: "42", Channel: "chat:1"})
+	require.NoError(t, err)
+
+	other := testTokens(t, &Tokens{Keys: []*TokenKey{{ID: "k1", Algorithm: "HS256", Secret: "other"}}, Issuer: "rr"})
+	forged, err := other.subscription(&SubscriptionTokenRequest{Sub: "42", Channel: "chat:1"})
+	require.NoError(t, err)
+
+	unknown := testTokens(t, &Tokens{Keys: []*TokenKey{{ID: "k9", Algorithm: "HS256", Secret: "secret"}}, Issuer: "rr"})
+	unknownKid, err := unknown.connection(&ConnectionTokenRequest{Sub: "42"})
+	require.NoError(t, err)
+
+	hs384 := testTokens(t, &Tokens{Keys: []*TokenKey{{ID: "k1", Algorithm: "HS384", Secret: "secret"}}, Issuer: "rr"})
+	wrongAlg, err := hs384.connection(&ConnectionTokenRequest{Sub: "42"})
+	require.NoError(t, err)
+
+	noIssuer := testTokens(t, &Tokens{Keys: []*TokenKey{{ID: "k1", Algorithm: "HS256", Secret: "secret"}}})
+	wrongIssuer, err := noIssuer.connection(&ConnectionTokenRequest{Sub: "42"})
+	require.NoError(t, err)
+
+	conn, err := tk.connection(&ConnectionTokenRequest{Sub: "42"})
+	require.NoError(t, err)
+
+	parts := strings.Split(tok.Token, ".")
+	notJSON := base64.RawURLEncoding.EncodeToString([]byte("{"))
+
+	tests := map[string]struct {
+		req    *VerifyTokenRequest
+		reason string
+	}{
+		"empty":             {&VerifyTokenRequest{}, tokenMalformed},
+		"bad header":        {&VerifyTokenRequest{Token: notJSON + "." + parts[1] + "." + parts[2]}, tokenMalformed},
+		"bad claims":        {&VerifyTokenRequest{Token: parts[0] + "." + notJSON + "." + parts[2]}, tokenMalformed},
+		"forged":            {&VerifyTokenRequest{Token: forged.Token}, tokenInvalidSignature},
+		"unknown kid":       {&VerifyTokenRequest{Token: unknownKid.Token}, tokenUnknownKey},
+		"algorithm":         {&VerifyTokenRequest{Token: wrongAlg.Token}, tokenUnsupportedAlgorithm},
+		"issuer":            {&VerifyTokenRequest{Token: wrongIssuer.Token}, tokenIssuerMismatch},
+		"channel":           {&VerifyTokenRequest{Token: tok.Token, Channel: "chat:2"}, tokenChannelMismatch},
+		"connection token":  {&VerifyTokenRequest{Token: conn.Token, Channel: "chat:1"}, tokenChannelMismatch},
+		"truncated":         {&VerifyTokenRequest{Token: parts[0] + "." + parts[1]}, tokenMalformed},
+		"invalid signature": {&VerifyTokenRequest{Token: parts[0] + "." + parts[1] + ".AAAA"}, tokenInvalidSignature},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := tk.verify(tc.req)
+			assert.False(t, res.Valid)
+			assert.Equal(t, tc.reason, res.Reason)
+		})
+	}
+
+	res := tk.verify(&VerifyTokenRequest{Token: tok.Token, Channel: "chat:1"})
+	assert.True(t, res.Valid)
+	assert.Equal(t, subscriptionToken, res.Type)
+	assert.Equal(t, "chat:1", res.Channel)
+}
+
+func TestVerifyTokenPublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	// the issuer holds the private keys, the verifier only the public ones
+	rsaIssuer := testTokens(t, &Tokens{Keys: []*TokenKey{{ID: "rsa", Algorithm: "RS512", File: writeKey(t, rsaKey)}}})
+	ecIssuer := testTokens(t, &Tokens{Keys: []*TokenKey{{ID: "ec", Algorithm: "ES384", File: writeKey(t, ecKey)}}})
+
+	verifier := testTokens(t, &Tokens{
+		Keys: []*TokenKey{
+			{ID: "hmac", Algorithm: "HS256", Secret: "secret"},
+			{ID: "rsa", Algorithm: "RS512", File: writePublicKey(t, &rsaKey.PublicKey)},
+			{ID: "ec", Algorithm: "ES384", File: writePublicKey(t, &ecKey.PublicKey)},
+		},
+	})
+
+	for _, issuer := range []*tokens{rsaIssuer, ecIssuer} {
+		tok, errT := issuer.connection(&ConnectionTokenRequest{Sub: "42"})
+		require.NoError(t, errT)
+
+		res := verifier.verify(&VerifyTokenRequest{Token: tok.Token})
+		assert.True(t, res.Valid, res.Reason)
+		assert.Equal(t, tok.Kid, res.Kid)
+
+		// the signature of the other key
+		parts := strings.Split(tok.Token, ".")
+		res = verifier.verify(&VerifyTokenRequest{Token: parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1"}`)) + "." + parts[2]})
+		assert.Equal(t, tokenInvalidSignature, res.Reason)
+	}
+
+	// a public key can not sign
+	cfg := &Tokens{Keys: []*TokenKey{{ID: "rsa", Algorithm: "RS512", File: writePublicKey(t, &rsaKey.PublicKey)}}}
+	require.NoError(t, cfg.InitDefaults())
+	_, err = newTokens(cfg)
+	require.Error(t, err)
+}
+
+func TestVerifyTokenAudience(t *testing.T) {
+	tk := testTokens(t, &Tokens{Keys: []*TokenKey{{Algorithm: "HS256", Secret: "secret"}}, Audience: "centrifugo"})
+
+	sign := func(claims string) string {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(claims))
+
+		sig, err := tk.active.sign([]byte(payload))
+		require.NoError(t, err)
+
+		return payload + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	assert.True(t, tk.verify(&VerifyTokenRequest{Token: sign(`{"sub":"1","aud":["web","centrifugo"]}`)}).Valid)
+	assert.Equal(t, tokenAudienceMismatch, tk.verify(&VerifyTokenRequest{Token: sign(`{"sub":"1","aud":"web"}`)}).Reason)
+	assert.Equal(t, tokenAudienceMismatch, tk.verify(&VerifyTokenRequest{Token: sign(`{"sub":"1"}`)}).Reason)
+
+	nbf := time.Now().Add(time.Hour).Unix()
+	res := tk.verify(&VerifyTokenRequest{Token: sign(`{"sub":"1","aud":"centrifugo","nbf":` + strconv.FormatInt(nbf, 10) + `}`)})
+	assert.Equal(t, tokenNotYetValid, res.Reason)
+}
+
+func TestVerifyTokenRPC(t *testing.T) {
+	tk := testTokens(t, &Tokens{Keys: []*TokenKey{{Algorithm: "HS256", Secret: "secret"}}})
+	r := &rpc{plugin: &Plugin{tokens: tk}, log: testLogger()}
+
+	tok := &Token{}
+	require.NoError(t, r.GenerateConnectionToken(&ConnectionTokenRequest{Sub: "42"}, tok))
+
+	out := &TokenVerification{}
+	require.NoError(t, r.VerifyToken(&VerifyTokenRequest{Token: tok.Token}, out))
+	assert.True(t, out.Valid)
+
+	require.Error(t, (&rpc{plugin: &Plugin{}, log: testLogger()}).VerifyToken(&VerifyTokenRequest{Token: tok.Token}, out))
+}